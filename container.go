@@ -1,6 +1,9 @@
 package maestro
 
-import "errors"
+import (
+	"errors"
+	"slices"
+)
 
 type OpType int
 
@@ -11,9 +14,23 @@ const (
 )
 
 type QueueUpdateMessage struct {
-	Data   interface{}
-	ID     string
-	OpType OpType
+	Data interface{}
+	// Before is the item's data before the change, for watchers that can
+	// provide it.
+	Before interface{}
+	// UpdateDescription lists the fields an update changed, for watchers that
+	// can provide it.
+	UpdateDescription *UpdateDescription
+	ID                string
+	// Namespace is the collection or table the change was made in, for
+	// watchers that can watch more than one. See Maestro.CreateRouter.
+	Namespace string
+	OpType    OpType
+}
+
+type UpdateDescription struct {
+	UpdatedFields map[string]interface{}
+	RemovedFields []string
 }
 
 type QueueItem interface {
@@ -27,6 +44,35 @@ type Container interface {
 	Len() int
 	Items() []QueueItem
 	Find(id string) (QueueItem, error)
+	// Remove deletes the item with the given id.
+	Remove(id string) error
+	// Replace swaps the item with the given id for item, keeping its place in
+	// the container where the ordering allows it.
+	Replace(id string, item QueueItem) error
+	// Requeue returns a previously popped item to the front of the container
+	// so it is the next item popped, subject to the container's ordering.
+	Requeue(item QueueItem)
+}
+
+type queueItem struct {
+	data any
+	id   string
+}
+
+// NewQueueItem returns a QueueItem holding the given id and data.
+func NewQueueItem(id string, data any) QueueItem {
+	return &queueItem{
+		id:   id,
+		data: data,
+	}
+}
+
+func (qi *queueItem) ID() string {
+	return qi.id
+}
+
+func (qi *queueItem) Data() any {
+	return qi.data
 }
 
 var (
@@ -48,6 +94,10 @@ func (sc *SliceContainer) Push(item QueueItem) {
 	sc.Elements = append(sc.Elements, item)
 }
 
+func (sc *SliceContainer) Requeue(item QueueItem) {
+	sc.Elements = slices.Insert(sc.Elements, 0, item)
+}
+
 func (sc *SliceContainer) Pop() (QueueItem, error) {
 	if len(sc.Elements) == 0 {
 		return nil, ErrQueueEmpty
@@ -85,3 +135,29 @@ func (sc *SliceContainer) Find(id string) (QueueItem, error) {
 	}
 	return nil, ErrItemNotFound
 }
+
+func (sc *SliceContainer) Remove(id string) error {
+	idx := sc.indexOf(id)
+	if idx == -1 {
+		return ErrItemNotFound
+	}
+
+	sc.Elements = slices.Delete(sc.Elements, idx, idx+1)
+	return nil
+}
+
+func (sc *SliceContainer) Replace(id string, item QueueItem) error {
+	idx := sc.indexOf(id)
+	if idx == -1 {
+		return ErrItemNotFound
+	}
+
+	sc.Elements[idx] = item
+	return nil
+}
+
+func (sc *SliceContainer) indexOf(id string) int {
+	return slices.IndexFunc(sc.Elements, func(item QueueItem) bool {
+		return item.ID() == id
+	})
+}
@@ -271,6 +271,92 @@ func TestSliceContainer_Find(t *testing.T) {
 	}
 }
 
+func TestSliceContainer_Remove(t *testing.T) {
+	tests := []struct {
+		expectedError error
+		name          string
+		id            string
+		expectedItems []maestro.QueueItem
+	}{
+		{
+			name: "Remove Item",
+			id:   testQueueItem(1).ID(),
+			expectedItems: []maestro.QueueItem{
+				testQueueItem(0),
+				testQueueItem(2),
+			},
+			expectedError: nil,
+		},
+		{
+			name:          "Remove Item Not Found",
+			id:            "notFound",
+			expectedItems: makeTestQueueItems(3),
+			expectedError: maestro.ErrItemNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &maestro.SliceContainer{
+				Elements: makeTestQueueItems(3),
+			}
+			err := sc.Remove(tt.id)
+			require.ErrorIs(t, err, tt.expectedError)
+			require.Equal(t, tt.expectedItems, sc.Elements, "Unexpected items in container after Remove()")
+		})
+	}
+}
+
+func TestSliceContainer_Replace(t *testing.T) {
+	replacement := &TestQueueItem{SetID: "testId1", SetData: "replaced"}
+
+	tests := []struct {
+		expectedError error
+		name          string
+		id            string
+		expectedItems []maestro.QueueItem
+	}{
+		{
+			name: "Replace Item",
+			id:   testQueueItem(1).ID(),
+			expectedItems: []maestro.QueueItem{
+				testQueueItem(0),
+				replacement,
+				testQueueItem(2),
+			},
+			expectedError: nil,
+		},
+		{
+			name:          "Replace Item Not Found",
+			id:            "notFound",
+			expectedItems: makeTestQueueItems(3),
+			expectedError: maestro.ErrItemNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &maestro.SliceContainer{
+				Elements: makeTestQueueItems(3),
+			}
+			err := sc.Replace(tt.id, replacement)
+			require.ErrorIs(t, err, tt.expectedError)
+			require.Equal(t, tt.expectedItems, sc.Elements, "Unexpected items in container after Replace()")
+		})
+	}
+}
+
+func TestSliceContainer_Requeue(t *testing.T) {
+	sc := &maestro.SliceContainer{
+		Elements: makeTestQueueItems(2),
+	}
+	sc.Requeue(testQueueItem(5))
+
+	require.Equal(t, []maestro.QueueItem{
+		testQueueItem(5),
+		testQueueItem(0),
+		testQueueItem(1),
+	}, sc.Elements)
+}
+
 func makeTestQueueItems(count int) []maestro.QueueItem {
 	items := []maestro.QueueItem{}
 
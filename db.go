@@ -1,9 +1,64 @@
 package maestro
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
 
 // Test Go Change
 
 type Watcher interface {
 	Watch(ctx context.Context, c chan QueueUpdateMessage) error
 }
+
+// WatcherState is reported to a watcher's state callback as it connects to its
+// source, loses the connection and stops.
+type WatcherState int
+
+const (
+	WatcherStateConnecting WatcherState = iota
+	WatcherStateWatching
+	WatcherStateReconnecting
+	WatcherStateStopped
+)
+
+func (s WatcherState) String() string {
+	switch s {
+	case WatcherStateConnecting:
+		return "connecting"
+	case WatcherStateWatching:
+		return "watching"
+	case WatcherStateReconnecting:
+		return "reconnecting"
+	case WatcherStateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultReconnect is the backoff watchers use between attempts to reach their
+// source after it fails, unless configured otherwise.
+var DefaultReconnect = Backoff{
+	Initial:    100 * time.Millisecond,
+	Max:        30 * time.Second,
+	Multiplier: DefaultBackoffMultiplier,
+}
+
+// TokenStore persists change stream resume tokens so a watcher can pick up
+// where it left off after a restart.
+type TokenStore interface {
+	// LoadToken returns the token saved under key, or nil if there is none.
+	LoadToken(ctx context.Context, key string) (bson.Raw, error)
+	SaveToken(ctx context.Context, key string, token bson.Raw) error
+}
+
+// OffsetStore persists how far a FileWatcher has read into each file so it can
+// pick up where it left off after a restart.
+type OffsetStore interface {
+	// LoadOffset returns the offset saved under key, or 0 if there is none.
+	LoadOffset(ctx context.Context, key string) (int64, error)
+	SaveOffset(ctx context.Context, key string, offset int64) error
+}
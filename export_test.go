@@ -0,0 +1,13 @@
+package maestro
+
+func ParseToMessage(d []byte) (BinaryAuthContentMessage, error) {
+	return (&BinaryAuthContentProtocol{}).parseToMessage(d)
+}
+
+// Placed returns the number of items the router remembers placing.
+func (r *Router) Placed() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.placed)
+}
@@ -0,0 +1,456 @@
+package maestro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileWatcher is a Watcher that tails JSON lines appended to a file, or to
+// every .jsonl and .ndjson file in a directory. Each line is a JSON object that
+// becomes one update, with the whole object as its data.
+type FileWatcher struct {
+	opts  FileWatcherOpts
+	tails map[string]*fileTail
+	// seen holds the files opened since Watch started. Files seen before are
+	// read from the start when they reappear, as they have been rotated.
+	seen    map[string]bool
+	idPath  []string
+	opPath  []string
+	readBuf []byte
+}
+
+type FileWatcherOpts struct {
+	// OffsetStore saves how far each file has been read so Watch resumes from
+	// there after a restart. Files are read from the start when it is nil.
+	OffsetStore OffsetStore
+	// OnInvalidLine is called with lines that are skipped because they aren't
+	// a JSON object, have no ID or have an unknown op.
+	OnInvalidLine func(path string, line []byte, err error)
+	// Path is the file or directory to tail. It doesn't need to exist yet.
+	Path string
+	// IDField is the dot separated path of the field holding each line's ID.
+	// Defaults to "id". String and number IDs are supported.
+	IDField string
+	// OpField is the dot separated path of the field holding the operation,
+	// one of "insert", "update", "replace" or "delete". Defaults to "op", and
+	// lines without it are inserts.
+	OpField string
+	// PollInterval is how often files are checked for new lines. Defaults to
+	// DefaultFilePollInterval.
+	PollInterval time.Duration
+}
+
+const DefaultFilePollInterval = 250 * time.Millisecond
+
+var (
+	ErrInvalidLine = errors.New("invalid line")
+	ErrUnknownOp   = errors.New("unknown op")
+)
+
+// fileExtensions are the files tailed when watching a directory.
+var fileExtensions = []string{".jsonl", ".ndjson"}
+
+// fileTail is a file being read by a FileWatcher.
+type fileTail struct {
+	file *os.File
+	info os.FileInfo
+	// partial holds the end of the file after the last complete line.
+	partial []byte
+	// offset is the end of the last complete line.
+	offset int64
+}
+
+func NewFileWatcher(opts FileWatcherOpts) (*FileWatcher, error) {
+	if opts.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	if opts.IDField == "" {
+		opts.IDField = "id"
+	}
+	if opts.OpField == "" {
+		opts.OpField = "op"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultFilePollInterval
+	}
+
+	return &FileWatcher{
+		opts:    opts,
+		tails:   nil,
+		seen:    nil,
+		idPath:  strings.Split(opts.IDField, "."),
+		opPath:  strings.Split(opts.OpField, "."),
+		readBuf: nil,
+	}, nil
+}
+
+// Watch sends an update for each line appended to the watched files until ctx
+// is cancelled. Each update's Namespace is its file's name without the
+// extension.
+//
+// A file that is truncated is read again from the start. A file that is
+// renamed or removed is read to the end before its replacement is read from
+// the start. With an OffsetStore, offsets are saved once the lines read in
+// each poll have been sent, so a restart may send the last few again. A file
+// rotated while Watch isn't running is only noticed if the new file is shorter
+// than the saved offset.
+func (fw *FileWatcher) Watch(ctx context.Context, c chan QueueUpdateMessage) error {
+	fw.tails = make(map[string]*fileTail)
+	fw.seen = make(map[string]bool)
+	fw.readBuf = make([]byte, 32*1024)
+	defer func() {
+		for _, t := range fw.tails {
+			_ = t.file.Close()
+		}
+		fw.tails = nil
+	}()
+
+	ticker := time.NewTicker(fw.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := fw.poll(ctx, c); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reads the new lines in every watched file.
+func (fw *FileWatcher) poll(ctx context.Context, c chan QueueUpdateMessage) error {
+	paths, err := fw.paths()
+	if err != nil {
+		return err
+	}
+
+	// files that have gone are read to the end one last time
+	for path := range fw.tails {
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		if err := fw.pollFile(ctx, c, path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// paths lists the files to tail, which is Path itself unless it is a
+// directory.
+func (fw *FileWatcher) paths() ([]string, error) {
+	info, err := os.Stat(fw.opts.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{fw.opts.Path}, nil
+	}
+
+	entries, err := os.ReadDir(fw.opts.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && slices.Contains(fileExtensions, filepath.Ext(e.Name())) {
+			paths = append(paths, filepath.Join(fw.opts.Path, e.Name()))
+		}
+	}
+
+	return paths, nil
+}
+
+// pollFile reads the new lines in path, following it if it was rotated or
+// truncated since the last poll.
+func (fw *FileWatcher) pollFile(ctx context.Context, c chan QueueUpdateMessage, path string) error {
+	info, err := os.Stat(path)
+	missing := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !missing {
+		return err
+	}
+
+	t := fw.tails[path]
+	if t != nil && (missing || !os.SameFile(t.info, info)) {
+		// rotated, so finish the old file before moving on to the new one
+		if err := fw.read(ctx, c, path, t); err != nil {
+			return err
+		}
+		_ = t.file.Close()
+		delete(fw.tails, path)
+		t = nil
+	}
+	if missing {
+		return nil
+	}
+
+	if t == nil {
+		if t, err = fw.open(ctx, path); err != nil {
+			return err
+		}
+		fw.tails[path] = t
+	} else if info.Size() < t.offset+int64(len(t.partial)) {
+		if err := t.rewind(); err != nil {
+			return err
+		}
+	}
+
+	return fw.read(ctx, c, path, t)
+}
+
+// open starts tailing path from its saved offset, or from the start if it was
+// rotated since Watch started.
+func (fw *FileWatcher) open(ctx context.Context, path string) (*fileTail, error) {
+	var offset int64
+	if fw.opts.OffsetStore != nil {
+		var err error
+		if !fw.seen[path] {
+			offset, err = fw.opts.OffsetStore.LoadOffset(ctx, path)
+		} else {
+			// forget the old file's offset in case we stop before reading this one
+			err = fw.opts.OffsetStore.SaveOffset(ctx, path, 0)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("offset: %w", err)
+		}
+	}
+	fw.seen[path] = true
+
+	f, err := os.Open(path) //nolint:gosec // tailing the configured files is the point
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	t := &fileTail{
+		file:    f,
+		info:    info,
+		partial: nil,
+		offset:  0,
+	}
+	if offset > info.Size() {
+		// truncated while we weren't watching
+		offset = 0
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		t.offset = offset
+	}
+
+	return t, nil
+}
+
+// rewind starts reading a truncated file again from the start.
+func (t *fileTail) rewind() error {
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	t.offset = 0
+	t.partial = nil
+	return nil
+}
+
+// read sends the complete lines added to t since the last read and saves the
+// new offset.
+func (fw *FileWatcher) read(ctx context.Context, c chan QueueUpdateMessage, path string, t *fileTail) error {
+	start := t.offset
+	ns := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	for {
+		n, err := t.file.Read(fw.readBuf)
+		t.partial = append(t.partial, fw.readBuf[:n]...)
+
+		for {
+			i := bytes.IndexByte(t.partial, '\n')
+			if i < 0 {
+				break
+			}
+			line := t.partial[:i]
+			t.partial = t.partial[i+1:]
+			t.offset += int64(i + 1)
+
+			if err := fw.sendLine(ctx, c, path, ns, line); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	// don't hold on to the buffer of a long line
+	t.partial = slices.Clone(t.partial)
+
+	if fw.opts.OffsetStore != nil && t.offset != start {
+		if err := fw.opts.OffsetStore.SaveOffset(ctx, path, t.offset); err != nil {
+			return fmt.Errorf("save offset: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (fw *FileWatcher) sendLine(ctx context.Context, c chan QueueUpdateMessage, path string, ns string, line []byte) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	msg, err := fw.updateMessage(line)
+	if err != nil {
+		if fw.opts.OnInvalidLine != nil {
+			fw.opts.OnInvalidLine(path, line, err)
+		}
+		return nil
+	}
+	msg.Namespace = ns
+
+	return send(ctx, c, msg)
+}
+
+// updateMessage decodes a line into an update.
+func (fw *FileWatcher) updateMessage(line []byte) (QueueUpdateMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return QueueUpdateMessage{}, fmt.Errorf("%w: %w", ErrInvalidLine, err)
+	} else if doc == nil {
+		return QueueUpdateMessage{}, fmt.Errorf("%w: not an object", ErrInvalidLine)
+	}
+
+	var id string
+	switch v, _ := lookupValue(doc, fw.idPath); v := v.(type) {
+	case string:
+		id = v
+	case json.Number:
+		id = v.String()
+	}
+	if id == "" {
+		return QueueUpdateMessage{}, fmt.Errorf("%w: no %s", ErrInvalidLine, fw.opts.IDField)
+	}
+
+	op := OpTypeInsert
+	if v, ok := lookupValue(doc, fw.opPath); ok {
+		name, _ := v.(string)
+		switch strings.ToLower(name) {
+		case "insert":
+			op = OpTypeInsert
+		case "update", "replace":
+			op = OpTypeUpdate
+		case "delete":
+			op = OpTypeDelete
+		default:
+			return QueueUpdateMessage{}, fmt.Errorf("%w: %w: %v", ErrInvalidLine, ErrUnknownOp, v)
+		}
+	}
+
+	return QueueUpdateMessage{
+		Data:              doc,
+		Before:            nil,
+		UpdateDescription: nil,
+		ID:                id,
+		Namespace:         "",
+		OpType:            op,
+	}, nil
+}
+
+// FileOffsetStore is an OffsetStore that keeps offsets as JSON in a file.
+type FileOffsetStore struct {
+	path  string
+	mutex sync.Mutex
+}
+
+var _ OffsetStore = (*FileOffsetStore)(nil)
+
+func NewFileOffsetStore(path string) *FileOffsetStore {
+	return &FileOffsetStore{
+		path:  path,
+		mutex: sync.Mutex{},
+	}
+}
+
+func (s *FileOffsetStore) LoadOffset(_ context.Context, key string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offsets, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	return offsets[key], nil
+}
+
+// SaveOffset rewrites the whole file, through a temporary file so a crash
+// can't leave it half written.
+func (s *FileOffsetStore) SaveOffset(_ context.Context, key string, offset int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	offsets, err := s.load()
+	if err != nil {
+		return err
+	}
+	offsets[key] = offset
+
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *FileOffsetStore) load() (map[string]int64, error) {
+	offsets := make(map[string]int64)
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return offsets, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
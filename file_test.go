@@ -0,0 +1,188 @@
+package maestro_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range lines {
+		_, err := f.WriteString(line)
+		require.NoError(t, err)
+	}
+}
+
+// watchFile runs a FileWatcher on opts in the background, returning its
+// updates.
+func watchFile(t *testing.T, opts maestro.FileWatcherOpts) chan maestro.QueueUpdateMessage {
+	t.Helper()
+
+	opts.PollInterval = time.Millisecond
+	w, err := maestro.NewFileWatcher(opts)
+	require.NoError(t, err)
+
+	c, _ := startWatch(t, w)
+	return c
+}
+
+func requireIDs(t *testing.T, c chan maestro.QueueUpdateMessage, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		require.Equal(t, id, receive(t, c).ID)
+	}
+	select {
+	case msg := <-c:
+		require.FailNow(t, "unexpected update", "id %s", msg.ID)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestFileWatcher_Lines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.jsonl")
+
+	var mutex sync.Mutex
+	var invalid []string
+	c := watchFile(t, maestro.FileWatcherOpts{
+		Path:    path,
+		IDField: "order.id",
+		OpField: "action",
+		OnInvalidLine: func(_ string, line []byte, _ error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			invalid = append(invalid, string(line))
+		},
+	})
+
+	appendLines(t, path,
+		`{"order": {"id": "a"}, "status": "new"}`+"\n",
+		"\n",
+		`{"order": {"id": 12345678901234567890}, "action": "UPDATE"}`+"\r\n",
+		`not json`+"\n",
+		`{"status": "no id"}`+"\n",
+		`{"order": {"id": "b"}, "action": "upsert"}`+"\n",
+		`{"order": {"id": "a"}, "action": "delete"}`+"\n",
+		`{"order": {"id": "c"}`,
+	)
+
+	require.Equal(t, maestro.QueueUpdateMessage{
+		Data:      map[string]any{"order": map[string]any{"id": "a"}, "status": "new"},
+		ID:        "a",
+		Namespace: "orders",
+		OpType:    maestro.OpTypeInsert,
+	}, receive(t, c))
+
+	update := receive(t, c)
+	require.Equal(t, "12345678901234567890", update.ID)
+	require.Equal(t, maestro.OpTypeUpdate, update.OpType)
+
+	require.Equal(t, maestro.OpTypeDelete, receive(t, c).OpType)
+
+	// the last line isn't sent until it is finished
+	requireIDs(t, c)
+	appendLines(t, path, "}\n")
+	requireIDs(t, c, "c")
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	require.Equal(t, []string{
+		`not json`,
+		`{"status": "no id"}`,
+		`{"order": {"id": "b"}, "action": "upsert"}`,
+	}, invalid)
+}
+
+func TestFileWatcher_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orders.jsonl")
+	appendLines(t, path, `{"id": "a"}`+"\n")
+
+	c := watchFile(t, maestro.FileWatcherOpts{Path: path})
+	requireIDs(t, c, "a")
+
+	// lines written just before the rename are still read from the old file
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(path, path+".1"))
+	_, err = f.WriteString(`{"id": "b"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	appendLines(t, path, `{"id": "c"}`+"\n")
+	requireIDs(t, c, "b", "c")
+
+	require.NoError(t, os.Truncate(path, 0))
+	requireIDs(t, c)
+	appendLines(t, path, `{"id": "d"}`+"\n")
+	requireIDs(t, c, "d")
+
+	require.NoError(t, os.Remove(path))
+	requireIDs(t, c)
+	appendLines(t, path, `{"id": "e"}`+"\n")
+	requireIDs(t, c, "e")
+}
+
+func TestFileWatcher_Directory(t *testing.T) {
+	dir := t.TempDir()
+	appendLines(t, filepath.Join(dir, "orders.jsonl"), `{"id": "a"}`+"\n")
+	appendLines(t, filepath.Join(dir, "notes.txt"), `{"id": "ignored"}`+"\n")
+
+	c := watchFile(t, maestro.FileWatcherOpts{Path: dir})
+	require.Equal(t, "orders", receive(t, c).Namespace)
+
+	appendLines(t, filepath.Join(dir, "invoices.ndjson"), `{"id": "b"}`+"\n")
+	msg := receive(t, c)
+	require.Equal(t, "b", msg.ID)
+	require.Equal(t, "invoices", msg.Namespace)
+	requireIDs(t, c)
+}
+
+func TestFileWatcher_ResumesFromOffset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orders.jsonl")
+	store := maestro.NewFileOffsetStore(filepath.Join(dir, "offsets.json"))
+	appendLines(t, path, `{"id": "a"}`+"\n", `{"id": "b"}`+"\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := maestro.NewFileWatcher(maestro.FileWatcherOpts{
+		Path:         path,
+		OffsetStore:  store,
+		PollInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	c := make(chan maestro.QueueUpdateMessage, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Watch(ctx, c)
+	}()
+	requireIDs(t, c, "a", "b")
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	offset, err := store.LoadOffset(context.Background(), path)
+	require.NoError(t, err)
+	require.Equal(t, int64(24), offset)
+
+	data, err := os.ReadFile(filepath.Join(dir, "offsets.json"))
+	require.NoError(t, err)
+	var saved map[string]int64
+	require.NoError(t, json.Unmarshal(data, &saved))
+	require.Equal(t, map[string]int64{path: 24}, saved)
+
+	appendLines(t, path, `{"id": "c"}`+"\n")
+	c = watchFile(t, maestro.FileWatcherOpts{Path: path, OffsetStore: store})
+	requireIDs(t, c, "c")
+}
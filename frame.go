@@ -0,0 +1,251 @@
+package maestro
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	frameSeparator = 0x1E
+
+	DefaultMaxAuthSize    = 64 << 10
+	DefaultMaxContentSize = 4 << 20
+)
+
+var (
+	ErrInvalidSeparator = errors.New("invalid separator")
+	ErrFrameTooLarge    = errors.New("frame too large")
+)
+
+type FrameReaderOpts struct {
+	// MaxAuthSize is the largest auth section accepted, DefaultMaxAuthSize when 0.
+	MaxAuthSize int
+	// MaxContentSize is the largest content section accepted,
+	// DefaultMaxContentSize when 0.
+	MaxContentSize int
+	// Resync makes the reader skip past the next terminator after a malformed
+	// frame, or stop at the bad terminator itself, so the following frame can
+	// still be read. Without it a malformed frame leaves the reader failed and
+	// every later read returns the same error.
+	Resync bool
+}
+
+// FrameReader decodes BinaryAuthContentMessage frames from a stream, where a
+// frame may arrive split across reads or coalesced with the frames around it.
+type FrameReader struct {
+	r    *bufio.Reader
+	err  error
+	opts FrameReaderOpts
+}
+
+func NewFrameReader(r io.Reader, opts FrameReaderOpts) *FrameReader {
+	if opts.MaxAuthSize == 0 {
+		opts.MaxAuthSize = DefaultMaxAuthSize
+	}
+	if opts.MaxContentSize == 0 {
+		opts.MaxContentSize = DefaultMaxContentSize
+	}
+
+	return &FrameReader{
+		r:    bufio.NewReader(r),
+		err:  nil,
+		opts: opts,
+	}
+}
+
+// ReadFrame returns the next frame. It returns io.EOF when the stream ends
+// cleanly between frames and io.ErrUnexpectedEOF when it ends inside one.
+func (fr *FrameReader) ReadFrame() (BinaryAuthContentMessage, error) {
+	if fr.err != nil {
+		return BinaryAuthContentMessage{}, fr.err
+	}
+
+	acm, err := fr.readFrame()
+	if err == nil {
+		return acm, nil
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidTerminator) && fr.opts.Resync:
+		// the bad terminator was read in place of the real one, so the frame
+		// has already been consumed and the next one starts here
+	case IsMalformedFrame(err) && fr.opts.Resync:
+		if syncErr := fr.resync(); syncErr != nil {
+			fr.err = syncErr
+		}
+	default:
+		fr.err = err
+	}
+
+	return BinaryAuthContentMessage{}, err
+}
+
+// IsMalformedFrame reports whether err was caused by a frame that broke the
+// wire format, as opposed to a failure of the underlying reader.
+func IsMalformedFrame(err error) bool {
+	return errors.Is(err, ErrInvalidSeparator) ||
+		errors.Is(err, ErrInvalidTerminator) ||
+		errors.Is(err, ErrFrameTooLarge)
+}
+
+func (fr *FrameReader) readFrame() (BinaryAuthContentMessage, error) {
+	acm := BinaryAuthContentMessage{}
+
+	ver := make([]byte, 4)
+	if _, err := io.ReadFull(fr.r, ver); err != nil {
+		// a clean EOF before the first byte of a frame ends the stream
+		if errors.Is(err, io.EOF) {
+			return acm, err
+		}
+		return acm, fmt.Errorf("readFrame: failed to read version: %w", err)
+	}
+	acm.Version = int(binary.BigEndian.Uint32(ver))
+
+	var err error
+	if err = fr.readSeparator(); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: after version: %w", err)
+	}
+
+	if acm.AuthSize, err = fr.readSize(fr.opts.MaxAuthSize); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: failed to read auth size: %w", err)
+	}
+
+	if err = fr.readSeparator(); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: after auth size: %w", err)
+	}
+
+	if acm.Auth, err = fr.readBytes(acm.AuthSize); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: failed to read auth: %w", err)
+	}
+
+	if err = fr.readSeparator(); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: after auth: %w", err)
+	}
+
+	if acm.ContentSize, err = fr.readSize(fr.opts.MaxContentSize); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: failed to read content size: %w", err)
+	}
+
+	if err = fr.readSeparator(); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: after content size: %w", err)
+	}
+
+	if acm.Content, err = fr.readBytes(acm.ContentSize); err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: failed to read content: %w", err)
+	}
+
+	term, err := fr.readBytes(3)
+	if err != nil {
+		return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: could not read terminator: %w", err)
+	}
+	for _, b := range term {
+		if b != frameSeparator {
+			return BinaryAuthContentMessage{}, fmt.Errorf("readFrame: invalid character in terminator: %w", ErrInvalidTerminator)
+		}
+	}
+
+	return acm, nil
+}
+
+func (fr *FrameReader) readSize(limit int) (int, error) {
+	b, err := fr.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+
+	// compare before converting, as a large size wraps negative in a 32-bit int
+	size := binary.BigEndian.Uint32(b)
+	if uint64(size) > uint64(limit) {
+		return 0, fmt.Errorf("%w: %d bytes exceeds limit of %d", ErrFrameTooLarge, size, limit)
+	}
+	return int(size), nil
+}
+
+func (fr *FrameReader) readSeparator() error {
+	b, err := fr.r.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if b != frameSeparator {
+		return ErrInvalidSeparator
+	}
+	return nil
+}
+
+// readBytes reads exactly n bytes from part way through a frame.
+func (fr *FrameReader) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(fr.r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+// resync discards input up to and including the next frame terminator.
+func (fr *FrameReader) resync() error {
+	run := 0
+	for run < 3 {
+		b, err := fr.r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b == frameSeparator {
+			run++
+		} else {
+			run = 0
+		}
+	}
+
+	return nil
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF for reads that happen
+// part way through a frame.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Marshal encodes the message as a single frame. The auth and content sizes are
+// taken from the lengths of Auth and Content rather than AuthSize and
+// ContentSize.
+func (m BinaryAuthContentMessage) Marshal() ([]byte, error) {
+	if m.Version < 0 || uint64(m.Version) > math.MaxUint32 {
+		return nil, fmt.Errorf("marshal: version %d out of range", m.Version)
+	}
+	if uint64(len(m.Auth)) > math.MaxUint32 || uint64(len(m.Content)) > math.MaxUint32 {
+		return nil, fmt.Errorf("marshal: %w", ErrFrameTooLarge)
+	}
+
+	b := make([]byte, 0, len(m.Auth)+len(m.Content)+19)
+	b = binary.BigEndian.AppendUint32(b, uint32(m.Version))
+	b = append(b, frameSeparator)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(m.Auth)))
+	b = append(b, frameSeparator)
+	b = append(b, m.Auth...)
+	b = append(b, frameSeparator)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(m.Content)))
+	b = append(b, frameSeparator)
+	b = append(b, m.Content...)
+	b = append(b, frameSeparator, frameSeparator, frameSeparator)
+
+	return b, nil
+}
+
+// WriteTo writes the message to w as a single frame.
+func (m BinaryAuthContentMessage) WriteTo(w io.Writer) (int64, error) {
+	b, err := m.Marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
@@ -0,0 +1,226 @@
+package maestro_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+func testFrameMessage(auth, content string) maestro.BinaryAuthContentMessage {
+	return maestro.BinaryAuthContentMessage{
+		Version:     1,
+		AuthSize:    len(auth),
+		Auth:        []byte(auth),
+		ContentSize: len(content),
+		Content:     []byte(content),
+	}
+}
+
+func TestFrameReader_ReadFrame(t *testing.T) {
+	badSeparator := makeBinaryAuthStream(testFrameMessage("auth", "bad"))
+	badSeparator[4] = 0x1F
+
+	badTerminator := makeBinaryAuthStream(testFrameMessage("auth", "bad"))
+	badTerminator[len(badTerminator)-2] = 0x00
+
+	maxSize := makeBinaryAuthStream(testFrameMessage("auth", "content"))
+	copy(maxSize[5:9], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+
+	tests := []struct {
+		stream  io.Reader
+		wantErr error
+		name    string
+		opts    maestro.FrameReaderOpts
+		want    []maestro.BinaryAuthContentMessage
+	}{
+		{
+			name: "Coalesced Frames",
+			stream: bytes.NewReader(bytes.Join([][]byte{
+				makeBinaryAuthStream(testFrameMessage("auth", "one")),
+				makeBinaryAuthStream(testFrameMessage("", "two")),
+			}, nil)),
+			want: []maestro.BinaryAuthContentMessage{
+				testFrameMessage("auth", "one"),
+				testFrameMessage("", "two"),
+			},
+			wantErr: io.EOF,
+		},
+		{
+			name: "Split Frames",
+			stream: iotest.OneByteReader(bytes.NewReader(bytes.Join([][]byte{
+				makeBinaryAuthStream(testFrameMessage("auth", "one")),
+				makeBinaryAuthStream(testFrameMessage("auth", "two")),
+			}, nil))),
+			want: []maestro.BinaryAuthContentMessage{
+				testFrameMessage("auth", "one"),
+				testFrameMessage("auth", "two"),
+			},
+			wantErr: io.EOF,
+		},
+		{
+			name:    "Stream Ends Inside Frame",
+			stream:  bytes.NewReader(makeBinaryAuthStream(testFrameMessage("auth", "content"))[:12]),
+			want:    []maestro.BinaryAuthContentMessage{},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "Auth Too Large",
+			stream:  bytes.NewReader(makeBinaryAuthStream(testFrameMessage("auth", "content"))),
+			opts:    maestro.FrameReaderOpts{MaxAuthSize: 3},
+			want:    []maestro.BinaryAuthContentMessage{},
+			wantErr: maestro.ErrFrameTooLarge,
+		},
+		{
+			name:    "Content Too Large",
+			stream:  bytes.NewReader(makeBinaryAuthStream(testFrameMessage("auth", "content"))),
+			opts:    maestro.FrameReaderOpts{MaxContentSize: 6},
+			want:    []maestro.BinaryAuthContentMessage{},
+			wantErr: maestro.ErrFrameTooLarge,
+		},
+		{
+			// wraps negative in a 32-bit int if converted before the check
+			name:    "Maximum Size",
+			stream:  bytes.NewReader(maxSize),
+			want:    []maestro.BinaryAuthContentMessage{},
+			wantErr: maestro.ErrFrameTooLarge,
+		},
+		{
+			name:    "Invalid Separator",
+			stream:  bytes.NewReader(badSeparator),
+			want:    []maestro.BinaryAuthContentMessage{},
+			wantErr: maestro.ErrInvalidSeparator,
+		},
+		{
+			name:    "Invalid Terminator",
+			stream:  bytes.NewReader(badTerminator),
+			want:    []maestro.BinaryAuthContentMessage{},
+			wantErr: maestro.ErrInvalidTerminator,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := maestro.NewFrameReader(tt.stream, tt.opts)
+
+			got := []maestro.BinaryAuthContentMessage{}
+			var err error
+			for {
+				var acm maestro.BinaryAuthContentMessage
+				acm, err = fr.ReadFrame()
+				if err != nil {
+					break
+				}
+				got = append(got, acm)
+			}
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.want, got)
+
+			// the reader stays failed once it has returned an error
+			_, again := fr.ReadFrame()
+			require.ErrorIs(t, again, tt.wantErr)
+		})
+	}
+}
+
+func TestFrameReader_Resync(t *testing.T) {
+	badSeparator := makeBinaryAuthStream(testFrameMessage("auth", "bad"))
+	badSeparator[9] = 0x00
+	badTerminator := makeBinaryAuthStream(testFrameMessage("auth", "bad"))
+	badTerminator[len(badTerminator)-2] = 0x00
+
+	stream := bytes.Join([][]byte{
+		makeBinaryAuthStream(testFrameMessage("auth", "one")),
+		badSeparator,
+		makeBinaryAuthStream(testFrameMessage("auth", "too large")),
+		makeBinaryAuthStream(testFrameMessage("auth", "two")),
+		badTerminator,
+		makeBinaryAuthStream(testFrameMessage("auth", "three")),
+	}, nil)
+
+	fr := maestro.NewFrameReader(bytes.NewReader(stream), maestro.FrameReaderOpts{
+		MaxContentSize: 5,
+		Resync:         true,
+	})
+
+	acm, err := fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, testFrameMessage("auth", "one"), acm)
+
+	_, err = fr.ReadFrame()
+	require.ErrorIs(t, err, maestro.ErrInvalidSeparator)
+	require.True(t, maestro.IsMalformedFrame(err))
+
+	_, err = fr.ReadFrame()
+	require.ErrorIs(t, err, maestro.ErrFrameTooLarge)
+
+	acm, err = fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, testFrameMessage("auth", "two"), acm)
+
+	// the bad terminator ends its frame, so the next one isn't skipped
+	_, err = fr.ReadFrame()
+	require.ErrorIs(t, err, maestro.ErrInvalidTerminator)
+
+	acm, err = fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, testFrameMessage("auth", "three"), acm)
+
+	_, err = fr.ReadFrame()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestBinaryAuthContentMessage_Marshal(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  maestro.BinaryAuthContentMessage
+	}{
+		{
+			name: "Auth And Content",
+			msg:  testFrameMessage("auth", "content"),
+		},
+		{
+			name: "Empty Auth",
+			msg:  testFrameMessage("", "content"),
+		},
+		{
+			name: "Empty Content",
+			msg:  testFrameMessage("auth", ""),
+		},
+		{
+			name: "Content Containing Separators",
+			msg:  testFrameMessage("auth", "\x1e\x1e\x1e\x1e"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.msg.Marshal()
+			require.NoError(t, err)
+			require.Equal(t, makeBinaryAuthStream(tt.msg), b)
+
+			parsed, err := maestro.ParseToMessage(b)
+			require.NoError(t, err)
+			require.Equal(t, tt.msg, parsed)
+
+			buf := &bytes.Buffer{}
+			n, err := tt.msg.WriteTo(buf)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(b)), n)
+
+			read, err := maestro.NewFrameReader(buf, maestro.FrameReaderOpts{}).ReadFrame()
+			require.NoError(t, err)
+			require.Equal(t, tt.msg, read)
+		})
+	}
+}
+
+func TestBinaryAuthContentMessage_MarshalInvalidVersion(t *testing.T) {
+	msg := testFrameMessage("auth", "content")
+	msg.Version = -1
+
+	_, err := msg.Marshal()
+	require.Error(t, err)
+}
@@ -0,0 +1,213 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUnsupportedAction = errors.New("unsupported action")
+	ErrInvalidContent    = errors.New("invalid message content")
+	ErrNoWriter          = errors.New("queue has no writer")
+)
+
+var (
+	_ Handler           = (*Maestro)(nil)
+	_ DisconnectHandler = (*Maestro)(nil)
+)
+
+// Handle implements Handler so a Server can route peer messages to the queues
+// managed by m. The session's peer must be registered in m.Peers, which is
+// done by passing m.Peers as ServerOpts.Peers.
+func (m *Maestro) Handle(_ context.Context, s *Session, msg Message) error {
+	//nolint:exhaustive // remaining actions are not handled by the broker
+	switch msg.ActionType {
+	case ActionTypeSubscribe:
+		return m.subscribe(s, msg)
+	case ActionTypeUnsubscribe:
+		return m.unsubscribe(msg)
+	case ActionTypeAcknowledge:
+		return m.acknowledge(msg)
+	case ActionTypeNack:
+		return m.nack(msg)
+	case ActionTypePublish:
+		return m.publish(s, msg)
+	default:
+		return fmt.Errorf("handle: %w: %s", ErrUnsupportedAction, msg.ActionType)
+	}
+}
+
+// subscribe adds the peer to the queue's subscribers and confirms it.
+func (m *Maestro) subscribe(s *Session, msg Message) error {
+	name, err := queueName(msg)
+	if err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	if _, err := m.Queue(name); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	if err := m.Peers.Subscribe(msg.ConnID, name); err != nil {
+		return err
+	}
+
+	return reply(s, msg, ActionTypeSubscribed, &Subscribed{
+		RequestID: requestID(msg.Content),
+		Queue:     name,
+	})
+}
+
+func (m *Maestro) unsubscribe(msg Message) error {
+	name, err := queueName(msg)
+	if err != nil {
+		return fmt.Errorf("unsubscribe: %w", err)
+	}
+
+	if err := m.Peers.Unsubscribe(msg.ConnID, name); err != nil {
+		return err
+	}
+
+	// hand back whatever the peer hadn't acknowledged so other subscribers
+	// get it without waiting for the visibility timeout
+	if q, err := m.Queue(name); err == nil {
+		if n := q.ReleaseConn(msg.ConnID); n > 0 {
+			m.Config.Logger.Info("released deliveries of unsubscribed peer",
+				slog.String("queue", name),
+				slog.String("conn_id", msg.ConnID),
+				slog.Int("count", n),
+			)
+		}
+	}
+
+	return nil
+}
+
+// HandleDisconnect requeues the deliveries the peer hadn't acknowledged on
+// every queue. They count as attempts, as they would had their visibility
+// timeout expired.
+func (m *Maestro) HandleDisconnect(connID string) {
+	for _, q := range m.Queues() {
+		if n := q.ExpireConn(connID); n > 0 {
+			m.Config.Logger.Info("requeued deliveries of disconnected peer",
+				slog.String("queue", q.Name),
+				slog.String("conn_id", connID),
+				slog.Int("count", n),
+			)
+		}
+	}
+}
+
+func (m *Maestro) acknowledge(msg Message) error {
+	q, tag, err := m.delivery(msg)
+	if err != nil {
+		return fmt.Errorf("acknowledge: %w", err)
+	}
+
+	return q.Acknowledge(msg.ConnID, tag)
+}
+
+func (m *Maestro) nack(msg Message) error {
+	ref, ok := msg.Content.(NackRef)
+	if !ok {
+		return fmt.Errorf("nack: %w: %T does not reject a delivery", ErrInvalidContent, msg.Content)
+	}
+
+	q, tag, err := m.delivery(msg)
+	if err != nil {
+		return fmt.Errorf("nack: %w", err)
+	}
+
+	return q.Nack(msg.ConnID, tag, ref.GetReason(), ref.GetRequeue())
+}
+
+// publish writes the item through the queue's Writer, then confirms it to the
+// publisher with the item's ID. Items published without an ID are given an
+// ObjectID. The item reaches the queue through its watcher like any other
+// write.
+func (m *Maestro) publish(s *Session, msg Message) error {
+	ref, ok := msg.Content.(PublishRef)
+	if !ok {
+		return fmt.Errorf("publish: %w: %T does not publish an item", ErrInvalidContent, msg.Content)
+	}
+
+	name, err := queueName(msg)
+	if err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	q, err := m.Queue(name)
+	if err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+	if q.Writer == nil {
+		return fmt.Errorf("publish: %w: %s", ErrNoWriter, name)
+	}
+
+	id := ref.GetID()
+	if id == "" {
+		id = primitive.NewObjectID().Hex()
+	}
+	if err := q.Writer.Write(NewQueueItem(id, ref.GetData())); err != nil {
+		return fmt.Errorf("publish: %w", err)
+	}
+
+	return reply(s, msg, ActionTypePublished, &Published{
+		RequestID: ref.GetRequestID(),
+		Queue:     name,
+		ID:        id,
+	})
+}
+
+// reply sends content to the peer msg came from. Messages handled without a
+// session, as in tests, get no reply.
+func reply(s *Session, msg Message, action ActionType, content any) error {
+	if s == nil {
+		return nil
+	}
+
+	return s.SendMessage(Message{
+		Content:    content,
+		Auth:       AuthInfo{},
+		ConnID:     msg.ConnID,
+		ActionType: action,
+	})
+}
+
+// delivery resolves the queue and delivery tag a message refers to.
+func (m *Maestro) delivery(msg Message) (*Queue, string, error) {
+	ref, ok := msg.Content.(DeliveryRef)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %T does not reference a delivery", ErrInvalidContent, msg.Content)
+	}
+
+	name, err := queueName(msg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	q, err := m.Queue(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return q, ref.GetDeliveryTag(), nil
+}
+
+func queueName(msg Message) (string, error) {
+	ref, ok := msg.Content.(QueueRef)
+	if !ok {
+		return "", fmt.Errorf("%w: %T does not reference a queue", ErrInvalidContent, msg.Content)
+	}
+
+	name := ref.GetQueue()
+	if name == "" {
+		return "", fmt.Errorf("%w: missing queue name", ErrInvalidContent)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,500 @@
+package maestro_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/charlieplate/maestro/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func pbFrame(t *testing.T, content proto.Message) []byte {
+	t.Helper()
+
+	a, err := anypb.New(content)
+	require.NoError(t, err)
+
+	b, err := proto.Marshal(&pb.Message{
+		ProtoVersion: "3.0.0",
+		Content:      a,
+	})
+	require.NoError(t, err)
+
+	frame, err := maestro.BinaryAuthContentMessage{Version: 1, Content: b}.Marshal()
+	require.NoError(t, err)
+
+	return frame
+}
+
+func TestMaestro_Handle(t *testing.T) {
+	tests := []struct {
+		wantErr error
+		msg     maestro.Message
+		name    string
+		want    []string
+	}{
+		{
+			name: "Subscribe",
+			msg: maestro.Message{
+				ActionType: maestro.ActionTypeSubscribe,
+				Content:    &pb.Subscribe{Queue: "orders"},
+				ConnID:     "1",
+			},
+			want: []string{"invoices", "orders"},
+		},
+		{
+			name: "Subscribe To Missing Queue",
+			msg: maestro.Message{
+				ActionType: maestro.ActionTypeSubscribe,
+				Content:    &pb.Subscribe{Queue: "missing"},
+				ConnID:     "1",
+			},
+			want:    []string{"invoices"},
+			wantErr: maestro.ErrQueueNotFound,
+		},
+		{
+			name: "Subscribe Without Queue Name",
+			msg: maestro.Message{
+				ActionType: maestro.ActionTypeSubscribe,
+				Content:    &pb.Subscribe{},
+				ConnID:     "1",
+			},
+			want:    []string{"invoices"},
+			wantErr: maestro.ErrInvalidContent,
+		},
+		{
+			name: "Subscribe With Invalid Content",
+			msg: maestro.Message{
+				ActionType: maestro.ActionTypeSubscribe,
+				Content:    []byte("orders"),
+				ConnID:     "1",
+			},
+			want:    []string{"invoices"},
+			wantErr: maestro.ErrInvalidContent,
+		},
+		{
+			name: "Unsubscribe",
+			msg: maestro.Message{
+				ActionType: maestro.ActionTypeUnsubscribe,
+				Content:    &pb.Unsubscribe{Queue: "invoices"},
+				ConnID:     "1",
+			},
+			want: []string{},
+		},
+		{
+			name: "Unsubscribe When Not Subscribed",
+			msg: maestro.Message{
+				ActionType: maestro.ActionTypeUnsubscribe,
+				Content:    &pb.Unsubscribe{Queue: "orders"},
+				ConnID:     "1",
+			},
+			want:    []string{"invoices"},
+			wantErr: maestro.ErrNotSubscribed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := maestro.New(testConfig())
+			for _, name := range []string{"orders", "invoices"} {
+				_, err := m.CreateQueue(name, nil, nil, maestro.QueueConfig{})
+				require.NoError(t, err)
+			}
+			m.Peers.AddPeer("1", &maestro.Peer{})
+			require.NoError(t, m.Peers.Subscribe("1", "invoices"))
+
+			err := m.Handle(context.Background(), nil, tt.msg)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.want, m.Peers.Subscriptions("1"))
+		})
+	}
+}
+
+func TestMaestro_HandleOverServer(t *testing.T) {
+	m := maestro.New(testConfig())
+	_, err := m.CreateQueue("orders", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	ts := startTestServer(t, maestro.ServerOpts{
+		Protocol: &maestro.BinaryAuthContentProtocol{
+			Authenticator: maestro.NewNilAuthenticator(),
+			Parser:        pb.NewProtobufParser(),
+		},
+		Handler: m,
+		Peers:   m.Peers,
+	})
+	conn := ts.dial(t)
+
+	_, err = conn.Write(pbFrame(t, &pb.Subscribe{Queue: "orders"}))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(m.Peers.Subscribers("orders")) == 1
+	}, time.Second, time.Millisecond)
+
+	peer := m.Peers.Subscribers("orders")[0]
+	require.NotNil(t, peer.Session)
+	require.Equal(t, conn.LocalAddr().String(), peer.Session.RemoteAddr().String())
+
+	_, err = conn.Write(pbFrame(t, &pb.Unsubscribe{Queue: "orders"}))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(m.Peers.Subscribers("orders")) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestMaestro_HandleAcknowledge(t *testing.T) {
+	m := maestro.New(testConfig())
+	q, err := m.CreateQueue("orders", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+	q.Container.Push(maestro.NewQueueItem("a", "one"))
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+
+	ack := func(connID string, content any) error {
+		return m.Handle(context.Background(), nil, maestro.Message{
+			ActionType: maestro.ActionTypeAcknowledge,
+			Content:    content,
+			ConnID:     connID,
+		})
+	}
+
+	require.ErrorIs(t, ack("1", &pb.Subscribe{Queue: "orders"}), maestro.ErrInvalidContent)
+	require.ErrorIs(t, ack("1", &pb.Ack{Queue: "missing", DeliveryTag: d.Tag}), maestro.ErrQueueNotFound)
+	require.ErrorIs(t, ack("2", &pb.Ack{Queue: "orders", DeliveryTag: d.Tag}), maestro.ErrDeliveryNotFound)
+	require.NoError(t, ack("1", &pb.Ack{Queue: "orders", DeliveryTag: d.Tag}))
+	require.Zero(t, q.InFlight())
+}
+
+// deliveryClient reads deliveries sent to a connection by a server using the
+// protobuf parser.
+type deliveryClient struct {
+	conn   net.Conn
+	frames *maestro.FrameReader
+}
+
+func newDeliveryClient(conn net.Conn) *deliveryClient {
+	return &deliveryClient{
+		conn:   conn,
+		frames: maestro.NewFrameReader(conn, maestro.FrameReaderOpts{}),
+	}
+}
+
+func (c *deliveryClient) next(t *testing.T) *pb.Delivery {
+	t.Helper()
+
+	d := &pb.Delivery{}
+	c.receive(t, d)
+	return d
+}
+
+// receive reads the next message sent to the client into content.
+func (c *deliveryClient) receive(t *testing.T, content proto.Message) {
+	t.Helper()
+
+	require.NoError(t, c.conn.SetReadDeadline(time.Now().Add(time.Second)))
+	frame, err := c.frames.ReadFrame()
+	require.NoError(t, err)
+
+	msg := &pb.Message{}
+	require.NoError(t, proto.Unmarshal(frame.Content, msg))
+	require.NoError(t, msg.GetContent().UnmarshalTo(content))
+}
+
+func (c *deliveryClient) send(t *testing.T, content proto.Message) {
+	t.Helper()
+
+	_, err := c.conn.Write(pbFrame(t, content))
+	require.NoError(t, err)
+}
+
+func startDeliveryTest(t *testing.T, cfg maestro.QueueConfig) (*maestro.Maestro, *maestro.Queue, *maestro.MemoryWatcher, *deliveryClient) {
+	t.Helper()
+
+	m, q, w, ts := startDeliveryServer(t, cfg)
+	return m, q, w, subscribeDeliveryClient(t, ts)
+}
+
+// startDeliveryServer runs m with an "orders" queue fed by w, served by ts.
+func startDeliveryServer(t *testing.T, cfg maestro.QueueConfig) (*maestro.Maestro, *maestro.Queue, *maestro.MemoryWatcher, *testServer) {
+	t.Helper()
+
+	m := maestro.New(testConfig())
+	w := maestro.NewMemoryWatcher()
+	q, err := m.CreateQueue("orders", w, nil, cfg)
+	require.NoError(t, err)
+	q.Writer = w
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	ts := startTestServer(t, maestro.ServerOpts{
+		Protocol: &maestro.BinaryAuthContentProtocol{
+			Authenticator: maestro.NewNilAuthenticator(),
+			Parser:        pb.NewProtobufParser(),
+		},
+		Handler: m,
+		Peers:   m.Peers,
+	})
+
+	return m, q, w, ts
+}
+
+// subscribeDeliveryClient connects to ts and subscribes to "orders".
+func subscribeDeliveryClient(t *testing.T, ts *testServer) *deliveryClient {
+	t.Helper()
+
+	client := newDeliveryClient(ts.dial(t))
+
+	client.send(t, &pb.Subscribe{Queue: "orders", RequestID: "sub-1"})
+	ok := &pb.SubscribeOk{}
+	client.receive(t, ok)
+	require.Equal(t, "orders", ok.GetQueue())
+	require.Equal(t, "sub-1", ok.GetRequestID())
+	require.NotEmpty(t, ts.Peers.Subscribers("orders"))
+
+	return client
+}
+
+func TestMaestro_DeliverAndAcknowledge(t *testing.T) {
+	_, q, w, client := startDeliveryTest(t, maestro.QueueConfig{})
+
+	w.Insert("a", "one")
+	w.Insert("b", "two")
+
+	first := client.next(t)
+	require.Equal(t, "orders", first.GetQueue())
+	require.Equal(t, "a", first.GetID())
+	require.Equal(t, []byte("one"), first.GetData())
+
+	second := client.next(t)
+	require.Equal(t, "b", second.GetID())
+	require.NotEqual(t, first.GetDeliveryTag(), second.GetDeliveryTag())
+
+	client.send(t, &pb.Ack{Queue: "orders", DeliveryTag: first.GetDeliveryTag()})
+	client.send(t, &pb.Ack{Queue: "orders", DeliveryTag: second.GetDeliveryTag()})
+	require.Eventually(t, func() bool {
+		return q.InFlight() == 0
+	}, time.Second, time.Millisecond)
+	require.Zero(t, q.Container.Len())
+}
+
+func TestMaestro_RedeliverAfterVisibilityTimeout(t *testing.T) {
+	_, q, w, client := startDeliveryTest(t, maestro.QueueConfig{VisibilityTimeout: 20 * time.Millisecond})
+
+	w.Insert("a", "one")
+
+	first := client.next(t)
+	require.Equal(t, "a", first.GetID())
+
+	// never acknowledged, so it comes back once the timeout expires
+	again := client.next(t)
+	require.Equal(t, "a", again.GetID())
+	require.NotEqual(t, first.GetDeliveryTag(), again.GetDeliveryTag())
+
+	client.send(t, &pb.Ack{Queue: "orders", DeliveryTag: again.GetDeliveryTag()})
+	require.Eventually(t, func() bool {
+		return q.InFlight() == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestMaestro_NackToDeadLetterQueue(t *testing.T) {
+	m, q, w, client := startDeliveryTest(t, maestro.QueueConfig{
+		MaxDeliveryAttempts: 2,
+		DeadLetterQueue:     "orders.dead",
+	})
+	dlq, err := m.CreateQueue("orders.dead", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	w.Insert("a", "one")
+
+	first := client.next(t)
+	require.Equal(t, uint32(1), first.GetAttempt())
+	client.send(t, &pb.Nack{Queue: "orders", DeliveryTag: first.GetDeliveryTag(), Reason: "retry", Requeue: true})
+
+	second := client.next(t)
+	require.Equal(t, "a", second.GetID())
+	require.Equal(t, uint32(2), second.GetAttempt())
+	client.send(t, &pb.Nack{Queue: "orders", DeliveryTag: second.GetDeliveryTag(), Reason: "still broken", Requeue: true})
+
+	require.Eventually(t, func() bool {
+		return dlq.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []maestro.QueueItem{&maestro.DeadLetterItem{
+		Payload:  "one",
+		ItemID:   "a",
+		Queue:    "orders",
+		Reason:   "still broken",
+		Attempts: 2,
+	}}, dlq.Container.Items())
+	require.Zero(t, q.InFlight())
+}
+
+func TestMaestro_UnsubscribeReleasesDeliveries(t *testing.T) {
+	m, q, w, client := startDeliveryTest(t, maestro.QueueConfig{MaxDeliveryAttempts: 1})
+
+	w.Insert("a", "one")
+	w.Insert("b", "two")
+	client.next(t)
+	client.next(t)
+
+	client.send(t, &pb.Unsubscribe{Queue: "orders", RequestID: "unsub-1"})
+	require.Eventually(t, func() bool {
+		return q.InFlight() == 0
+	}, time.Second, time.Millisecond)
+	require.Empty(t, m.Peers.Subscribers("orders"))
+	require.Equal(t, []maestro.QueueItem{
+		maestro.NewQueueItem("a", "one"),
+		maestro.NewQueueItem("b", "two"),
+	}, q.Container.Items())
+
+	// released deliveries don't use up an attempt
+	client.send(t, &pb.Subscribe{Queue: "orders", RequestID: "sub-2"})
+	client.receive(t, &pb.SubscribeOk{})
+	d := client.next(t)
+	require.Equal(t, "a", d.GetID())
+	require.Equal(t, uint32(1), d.GetAttempt())
+}
+
+func TestMaestro_DisconnectRequeuesDeliveries(t *testing.T) {
+	m, q, w, ts := startDeliveryServer(t, maestro.QueueConfig{
+		MaxDeliveryAttempts: 2,
+		DeadLetterQueue:     "orders.dead",
+	})
+	dlq, err := m.CreateQueue("orders.dead", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	client := subscribeDeliveryClient(t, ts)
+	w.Insert("a", "one")
+	require.Equal(t, uint32(1), client.next(t).GetAttempt())
+	require.NoError(t, client.conn.Close())
+
+	require.Eventually(t, func() bool {
+		return q.InFlight() == 0 && q.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	// the disconnect counted as an attempt, so losing the next consumer too
+	// dead-letters the item
+	client = subscribeDeliveryClient(t, ts)
+	require.Equal(t, uint32(2), client.next(t).GetAttempt())
+	require.NoError(t, client.conn.Close())
+
+	require.Eventually(t, func() bool {
+		return dlq.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []maestro.QueueItem{&maestro.DeadLetterItem{
+		Payload:  "one",
+		ItemID:   "a",
+		Queue:    "orders",
+		Reason:   maestro.ReasonDisconnected,
+		Attempts: 2,
+	}}, dlq.Container.Items())
+	require.Zero(t, q.Container.Len())
+	require.Zero(t, q.InFlight())
+}
+
+func TestMaestro_Publish(t *testing.T) {
+	m, _, _, client := startDeliveryTest(t, maestro.QueueConfig{})
+
+	client.send(t, &pb.Publish{Queue: "orders", Data: []byte("one")})
+	ok := &pb.PublishOk{}
+	client.receive(t, ok)
+	require.Equal(t, "orders", ok.GetQueue())
+	require.Len(t, ok.GetID(), 24, "should be given an ObjectID")
+
+	// the published item reaches the queue through its watcher
+	d := client.next(t)
+	require.Equal(t, ok.GetID(), d.GetID())
+	require.Equal(t, []byte("one"), d.GetData())
+
+	client.send(t, &pb.Publish{Queue: "orders", ID: "mine"})
+	client.receive(t, ok)
+	require.Equal(t, "mine", ok.GetID())
+
+	_, err := m.CreateQueue("readonly", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+	publish := func(content any) error {
+		return m.Handle(context.Background(), nil, maestro.Message{
+			ActionType: maestro.ActionTypePublish,
+			Content:    content,
+			ConnID:     "1",
+		})
+	}
+	require.ErrorIs(t, publish(&pb.Publish{Queue: "readonly"}), maestro.ErrNoWriter)
+	require.ErrorIs(t, publish(&pb.Publish{Queue: "missing"}), maestro.ErrQueueNotFound)
+	require.ErrorIs(t, publish(&pb.Subscribe{Queue: "orders"}), maestro.ErrInvalidContent)
+}
+
+func TestMaestro_ErrorReplies(t *testing.T) {
+	_, _, _, client := startDeliveryTest(t, maestro.QueueConfig{})
+
+	tests := []struct {
+		content proto.Message
+		name    string
+		code    pb.ErrorCode
+	}{
+		{
+			name:    "Queue Not Found",
+			content: &pb.Subscribe{Queue: "missing", RequestID: "1"},
+			code:    pb.ErrorCode_ERROR_CODE_QUEUE_NOT_FOUND,
+		},
+		{
+			name:    "Delivery Not Found",
+			content: &pb.Ack{Queue: "orders", DeliveryTag: "missing", RequestID: "2"},
+			code:    pb.ErrorCode_ERROR_CODE_DELIVERY_NOT_FOUND,
+		},
+		{
+			name:    "Not Subscribed",
+			content: &pb.Unsubscribe{Queue: "other", RequestID: "3"},
+			code:    pb.ErrorCode_ERROR_CODE_NOT_SUBSCRIBED,
+		},
+		{
+			name:    "Unsupported Action",
+			content: &pb.PublishOk{Queue: "orders", RequestID: "4"},
+			code:    pb.ErrorCode_ERROR_CODE_UNSUPPORTED_ACTION,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.send(t, tt.content)
+
+			reply := &pb.Error{}
+			client.receive(t, reply)
+			require.Equal(t, tt.code, reply.GetCode(), reply.GetMessage())
+			require.Equal(t, tt.content.(maestro.RequestRef).GetRequestID(), reply.GetRequestID())
+		})
+	}
+
+	t.Run("Unparseable", func(t *testing.T) {
+		client.send(t, &pb.Message{})
+
+		reply := &pb.Error{}
+		client.receive(t, reply)
+		require.Equal(t, pb.ErrorCode_ERROR_CODE_INVALID_MESSAGE, reply.GetCode())
+		require.Empty(t, reply.GetRequestID())
+	})
+}
+
+func TestNewErrorReply(t *testing.T) {
+	err := fmt.Errorf("authenticate: %w", maestro.ErrUnauthorized)
+	require.Equal(t, &maestro.ErrorReply{
+		RequestID: "1",
+		Message:   "authenticate: unauthorized",
+		Code:      maestro.ErrorCodeUnauthorized,
+	}, maestro.NewErrorReply("1", err))
+
+	require.Equal(t, maestro.ErrorCodeUnknown, maestro.NewErrorReply("", errors.New("boom")).Code)
+}
@@ -0,0 +1,220 @@
+package maestro
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// Prioritized is implemented by queue items that carry their own priority.
+// Items with a higher priority are popped first.
+type Prioritized interface {
+	Priority() int
+}
+
+// CompareFunc orders two queue items. It returns a negative number when a
+// should be popped before b, a positive number when b should be popped first
+// and zero when neither takes precedence.
+type CompareFunc func(a, b QueueItem) int
+
+// ComparePriority orders items by Prioritized, highest priority first. Items
+// that do not implement Prioritized have a priority of 0.
+func ComparePriority(a, b QueueItem) int {
+	return cmp.Compare(priorityOf(b), priorityOf(a))
+}
+
+func priorityOf(item QueueItem) int {
+	if p, ok := item.(Prioritized); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+type heapEntry struct {
+	item  QueueItem
+	seq   int64
+	index int
+}
+
+// entryHeap implements heap.Interface. Entries that compare equal are ordered
+// by their sequence number so that equal priorities pop in FIFO order.
+type entryHeap struct {
+	compare CompareFunc
+	entries []*heapEntry
+}
+
+func (h *entryHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *entryHeap) Less(i, j int) bool {
+	return h.less(h.entries[i], h.entries[j])
+}
+
+func (h *entryHeap) less(a, b *heapEntry) bool {
+	if c := h.compare(a.item, b.item); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+func (h *entryHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *entryHeap) Push(x any) {
+	e := x.(*heapEntry) //nolint:errcheck,forcetypeassert // only heapEntry values are pushed
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *entryHeap) Pop() any {
+	n := len(h.entries)
+	e := h.entries[n-1]
+	h.entries[n-1] = nil
+	h.entries = h.entries[:n-1]
+	e.index = -1
+	return e
+}
+
+// HeapContainer is a priority queue. Push and Pop are O(log n) and Find is a
+// map lookup. Items are ordered by QueueConfig.Compare, falling back to
+// ComparePriority, and items of equal priority are popped in the order they
+// were pushed.
+type HeapContainer struct {
+	heap  *entryHeap
+	index map[string][]*heapEntry
+	seq   int64
+	// front counts down from -1 so requeued items sort ahead of every pushed
+	// item of the same priority.
+	front int64
+}
+
+func NewHeapContainer(cfg QueueConfig) *HeapContainer {
+	compare := cfg.Compare
+	if compare == nil {
+		compare = ComparePriority
+	}
+
+	return &HeapContainer{
+		heap: &entryHeap{
+			compare: compare,
+			entries: make([]*heapEntry, 0),
+		},
+		index: make(map[string][]*heapEntry),
+		seq:   0,
+		front: -1,
+	}
+}
+
+func (hc *HeapContainer) Push(item QueueItem) {
+	e := &heapEntry{
+		item:  item,
+		seq:   hc.seq,
+		index: -1,
+	}
+	hc.seq++
+
+	heap.Push(hc.heap, e)
+	hc.index[item.ID()] = append(hc.index[item.ID()], e)
+}
+
+// Requeue puts item ahead of every other item of the same priority. Higher
+// priority items are still popped first.
+func (hc *HeapContainer) Requeue(item QueueItem) {
+	e := &heapEntry{
+		item:  item,
+		seq:   hc.front,
+		index: -1,
+	}
+	hc.front--
+
+	heap.Push(hc.heap, e)
+	hc.index[item.ID()] = append(hc.index[item.ID()], e)
+}
+
+func (hc *HeapContainer) Pop() (QueueItem, error) {
+	if hc.heap.Len() == 0 {
+		return nil, ErrQueueEmpty
+	}
+
+	e := heap.Pop(hc.heap).(*heapEntry) //nolint:errcheck,forcetypeassert // entryHeap only holds heapEntry values
+	hc.unindex(e)
+
+	return e.item, nil
+}
+
+func (hc *HeapContainer) Len() int {
+	return hc.heap.Len()
+}
+
+// Items returns the items in the order they would be popped.
+func (hc *HeapContainer) Items() []QueueItem {
+	entries := slices.Clone(hc.heap.entries)
+	slices.SortFunc(entries, func(a, b *heapEntry) int {
+		if hc.heap.less(a, b) {
+			return -1
+		}
+		return 1
+	})
+
+	items := make([]QueueItem, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, e.item)
+	}
+
+	return items
+}
+
+func (hc *HeapContainer) Find(id string) (QueueItem, error) {
+	entries, ok := hc.index[id]
+	if !ok {
+		return nil, ErrItemNotFound
+	}
+	return entries[0].item, nil
+}
+
+func (hc *HeapContainer) Remove(id string) error {
+	entries, ok := hc.index[id]
+	if !ok {
+		return ErrItemNotFound
+	}
+
+	e := entries[0]
+	heap.Remove(hc.heap, e.index)
+	hc.unindex(e)
+
+	return nil
+}
+
+// Replace swaps the item in place and re-sorts it, so a changed priority
+// takes effect while the item keeps its original FIFO position among equals.
+func (hc *HeapContainer) Replace(id string, item QueueItem) error {
+	entries, ok := hc.index[id]
+	if !ok {
+		return ErrItemNotFound
+	}
+
+	e := entries[0]
+	hc.unindex(e)
+	e.item = item
+	heap.Fix(hc.heap, e.index)
+	hc.index[item.ID()] = append(hc.index[item.ID()], e)
+
+	return nil
+}
+
+func (hc *HeapContainer) unindex(e *heapEntry) {
+	id := e.item.ID()
+	entries := slices.DeleteFunc(hc.index[id], func(other *heapEntry) bool {
+		return other == e
+	})
+
+	if len(entries) == 0 {
+		delete(hc.index, id)
+	} else {
+		hc.index[id] = entries
+	}
+}
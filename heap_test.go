@@ -0,0 +1,237 @@
+package maestro_test
+
+import (
+	"cmp"
+	"fmt"
+	"testing"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+type TestPriorityItem struct {
+	SetID       string
+	SetData     string
+	SetPriority int
+}
+
+func (t *TestPriorityItem) ID() string {
+	return t.SetID
+}
+
+func (t *TestPriorityItem) Data() any {
+	return t.SetData
+}
+
+func (t *TestPriorityItem) Priority() int {
+	return t.SetPriority
+}
+
+func testPriorityItem(idx int, priority int) *TestPriorityItem {
+	return &TestPriorityItem{
+		SetID:       fmt.Sprintf("testId%d", idx),
+		SetData:     fmt.Sprintf("testData%d", idx),
+		SetPriority: priority,
+	}
+}
+
+func TestNewHeapContainer(t *testing.T) {
+	hc := maestro.NewHeapContainer(maestro.QueueConfig{})
+	require.Implements(t, (*maestro.Container)(nil), hc)
+	require.Zero(t, hc.Len())
+	require.Equal(t, []maestro.QueueItem{}, hc.Items())
+}
+
+func TestHeapContainer_Pop(t *testing.T) {
+	tests := []struct {
+		cfg  maestro.QueueConfig
+		name string
+		push []maestro.QueueItem
+		want []maestro.QueueItem
+	}{
+		{
+			name: "Highest Priority First",
+			push: []maestro.QueueItem{
+				testPriorityItem(0, 1),
+				testPriorityItem(1, 5),
+				testPriorityItem(2, 3),
+			},
+			want: []maestro.QueueItem{
+				testPriorityItem(1, 5),
+				testPriorityItem(2, 3),
+				testPriorityItem(0, 1),
+			},
+		},
+		{
+			name: "Equal Priorities Are FIFO",
+			push: []maestro.QueueItem{
+				testPriorityItem(0, 1),
+				testPriorityItem(1, 2),
+				testPriorityItem(2, 1),
+				testPriorityItem(3, 2),
+				testPriorityItem(4, 1),
+			},
+			want: []maestro.QueueItem{
+				testPriorityItem(1, 2),
+				testPriorityItem(3, 2),
+				testPriorityItem(0, 1),
+				testPriorityItem(2, 1),
+				testPriorityItem(4, 1),
+			},
+		},
+		{
+			name: "Items Without Priority Are FIFO",
+			push: makeTestQueueItems(4),
+			want: makeTestQueueItems(4),
+		},
+		{
+			name: "Comparator From Config",
+			cfg: maestro.QueueConfig{
+				Compare: func(a, b maestro.QueueItem) int {
+					return cmp.Compare(a.ID(), b.ID())
+				},
+			},
+			push: []maestro.QueueItem{
+				testQueueItem(2),
+				testQueueItem(0),
+				testQueueItem(1),
+			},
+			want: makeTestQueueItems(3),
+		},
+		{
+			name: "Pop From Empty Container",
+			push: []maestro.QueueItem{},
+			want: []maestro.QueueItem{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := maestro.NewHeapContainer(tt.cfg)
+			for _, item := range tt.push {
+				hc.Push(item)
+			}
+
+			require.Equal(t, tt.want, hc.Items(), "Items() did not return pop order")
+
+			got := []maestro.QueueItem{}
+			for range len(tt.push) {
+				item, err := hc.Pop()
+				require.NoError(t, err)
+				got = append(got, item)
+			}
+			require.Equal(t, tt.want, got, "Pop() returned items out of order")
+
+			_, err := hc.Pop()
+			require.ErrorIs(t, err, maestro.ErrQueueEmpty)
+			require.Zero(t, hc.Len())
+		})
+	}
+}
+
+func TestHeapContainer_Find(t *testing.T) {
+	tests := []struct {
+		want          maestro.QueueItem
+		expectedError error
+		name          string
+		id            string
+		pop           int
+	}{
+		{
+			name: "Find Item",
+			id:   "testId3",
+			want: testPriorityItem(3, 3),
+		},
+		{
+			name:          "Find Item Not Found",
+			id:            "notFound",
+			expectedError: maestro.ErrItemNotFound,
+		},
+		{
+			name:          "Find Popped Item",
+			id:            "testId4",
+			pop:           1,
+			expectedError: maestro.ErrItemNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := maestro.NewHeapContainer(maestro.QueueConfig{})
+			for i := range 5 {
+				hc.Push(testPriorityItem(i, i))
+			}
+			for range tt.pop {
+				_, err := hc.Pop()
+				require.NoError(t, err)
+			}
+
+			item, err := hc.Find(tt.id)
+			require.ErrorIs(t, err, tt.expectedError)
+			if tt.want == nil {
+				require.Nil(t, item)
+			} else {
+				require.Equal(t, tt.want, item)
+			}
+		})
+	}
+}
+
+func TestHeapContainer_Remove(t *testing.T) {
+	hc := maestro.NewHeapContainer(maestro.QueueConfig{})
+	for i := range 5 {
+		hc.Push(testPriorityItem(i, i))
+	}
+
+	require.NoError(t, hc.Remove("testId4"))
+	require.NoError(t, hc.Remove("testId1"))
+	require.ErrorIs(t, hc.Remove("testId1"), maestro.ErrItemNotFound)
+
+	require.Equal(t, []maestro.QueueItem{
+		testPriorityItem(3, 3),
+		testPriorityItem(2, 2),
+		testPriorityItem(0, 0),
+	}, hc.Items())
+
+	_, err := hc.Find("testId4")
+	require.ErrorIs(t, err, maestro.ErrItemNotFound)
+}
+
+func TestHeapContainer_Replace(t *testing.T) {
+	hc := maestro.NewHeapContainer(maestro.QueueConfig{})
+	for i := range 3 {
+		hc.Push(testPriorityItem(i, 1))
+	}
+
+	require.NoError(t, hc.Replace("testId2", testPriorityItem(2, 5)))
+	require.NoError(t, hc.Replace("testId1", testPriorityItem(1, 1)))
+	require.ErrorIs(t, hc.Replace("notFound", testPriorityItem(9, 9)), maestro.ErrItemNotFound)
+
+	require.Equal(t, []maestro.QueueItem{
+		testPriorityItem(2, 5),
+		testPriorityItem(0, 1),
+		testPriorityItem(1, 1),
+	}, hc.Items())
+
+	item, err := hc.Find("testId2")
+	require.NoError(t, err)
+	require.Equal(t, testPriorityItem(2, 5), item)
+}
+
+func TestHeapContainer_Requeue(t *testing.T) {
+	hc := maestro.NewHeapContainer(maestro.QueueConfig{})
+	for i := range 3 {
+		hc.Push(testPriorityItem(i, 1))
+	}
+	hc.Requeue(testPriorityItem(3, 1))
+	hc.Requeue(testPriorityItem(4, 1))
+	hc.Push(testPriorityItem(5, 2))
+
+	// requeued items go ahead of their priority but never jump a higher one
+	require.Equal(t, []maestro.QueueItem{
+		testPriorityItem(5, 2),
+		testPriorityItem(4, 1),
+		testPriorityItem(3, 1),
+		testPriorityItem(0, 1),
+		testPriorityItem(1, 1),
+		testPriorityItem(2, 1),
+	}, hc.Items())
+}
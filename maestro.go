@@ -1,7 +1,15 @@
 package maestro
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Config struct {
@@ -10,5 +18,326 @@ type Config struct {
 
 type Maestro struct {
 	Config Config
-	Queues []Queue
+	// Peers tracks the peers subscribed to each queue. Pass it to NewServer
+	// through ServerOpts.Peers so connections are registered here.
+	Peers   *PeerMap
+	queues  map[string]*Queue
+	routers map[string]*Router
+	// runCtx is set while Run is active so that queues created after Run
+	// starts get their watcher started straight away.
+	runCtx context.Context //nolint:containedctx // tracks the lifetime of Run
+	errs   []error
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+}
+
+var (
+	ErrQueueExists      = errors.New("queue already exists")
+	ErrQueueNotFound    = errors.New("queue not found")
+	ErrAlreadyRunning   = errors.New("maestro is already running")
+	ErrPeerNotConnected = errors.New("peer is not connected")
+)
+
+func New(cfg Config) *Maestro {
+	if cfg.Logger.Handler() == nil {
+		cfg.Logger = *slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{}))
+	}
+
+	return &Maestro{
+		Config:  cfg,
+		Peers:   NewPeerMap(),
+		queues:  make(map[string]*Queue),
+		routers: make(map[string]*Router),
+		runCtx:  nil,
+		errs:    nil,
+		wg:      sync.WaitGroup{},
+		mutex:   sync.Mutex{},
+	}
+}
+
+// CreateQueue registers a queue fed by w. The container is wrapped in a
+// SyncContainer so the watcher and consumers can share it, and defaults to a
+// SliceContainer when nil.
+func (m *Maestro) CreateQueue(name string, w Watcher, c Container, cfg QueueConfig) (*Queue, error) {
+	if c == nil {
+		c = NewSliceContainer()
+	}
+	if _, ok := c.(*SyncContainer); !ok {
+		c = NewSyncContainer(c)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.queues[name]; ok {
+		return nil, fmt.Errorf("createQueue: %w: %s", ErrQueueExists, name)
+	}
+
+	q := &Queue{
+		Container: c,
+		Watcher:   w,
+		Writer:    nil,
+		cancel:    nil,
+		lookup:    m.Queue,
+		settled:   m.settled,
+		inflight:  make(map[string]*Delivery),
+		attempts:  make(map[string]int),
+		delayed:   nil,
+		Cfg:       cfg,
+		Name:      name,
+		nextTag:   0,
+		mutex:     sync.Mutex{},
+	}
+	m.queues[name] = q
+
+	if m.runCtx != nil {
+		m.start(m.runCtx, q)
+	}
+
+	return q, nil
+}
+
+// DeleteQueue removes the queue, stops its watcher and drops its subscribers.
+func (m *Maestro) DeleteQueue(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q, ok := m.queues[name]
+	if !ok {
+		return fmt.Errorf("deleteQueue: %w: %s", ErrQueueNotFound, name)
+	}
+
+	if q.cancel != nil {
+		q.cancel()
+	}
+	delete(m.queues, name)
+	m.Peers.RemoveQueue(name)
+
+	return nil
+}
+
+func (m *Maestro) Queue(name string) (*Queue, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q, ok := m.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("queue: %w: %s", ErrQueueNotFound, name)
+	}
+	return q, nil
+}
+
+// Queues returns every registered queue ordered by name.
+func (m *Maestro) Queues() []*Queue {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	queues := make([]*Queue, 0, len(m.queues))
+	for _, q := range m.queues {
+		queues = append(queues, q)
+	}
+	slices.SortFunc(queues, func(a, b *Queue) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return queues
+}
+
+// Run starts every queue's watcher, applying the updates they emit, and
+// delivers queued items to subscribers until ctx is cancelled. It returns once
+// all queues have stopped, with any errors that ended a queue early.
+func (m *Maestro) Run(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.runCtx != nil {
+		m.mutex.Unlock()
+		return ErrAlreadyRunning
+	}
+	m.runCtx = ctx
+	for _, q := range m.queues {
+		m.start(ctx, q)
+	}
+	for _, r := range m.routers {
+		m.startRouter(ctx, r)
+	}
+	m.mutex.Unlock()
+
+	m.Config.Logger.Info("maestro started", slog.Int("queues", len(m.Queues())))
+	<-ctx.Done()
+	m.Config.Logger.Info("stopping maestro")
+
+	// Clearing runCtx under the lock guarantees no new watcher is added to the
+	// wait group once we start waiting on it.
+	m.mutex.Lock()
+	m.runCtx = nil
+	m.mutex.Unlock()
+
+	m.wg.Wait()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := errors.Join(m.errs...)
+	m.errs = nil
+
+	return err
+}
+
+// start runs the queue's watcher, dispatcher and visibility timeout sweeper
+// in the background. The caller must hold the lock.
+func (m *Maestro) start(ctx context.Context, q *Queue) {
+	ctx, cancel := context.WithCancel(ctx)
+	q.cancel = cancel
+
+	workers := []func(context.Context, *Queue) error{m.dispatch, m.sweep}
+	if q.Watcher != nil {
+		workers = append(workers, m.watch)
+	}
+
+	for _, work := range workers {
+		m.spawn(ctx, "queue", q.Name, func(ctx context.Context) error {
+			return work(ctx, q)
+		})
+	}
+}
+
+// spawn runs work in the background, recording any error it returns for Run.
+// kind and name identify what stopped in logs and errors.
+func (m *Maestro) spawn(ctx context.Context, kind string, name string, work func(context.Context) error) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		if err := work(ctx); err != nil {
+			m.Config.Logger.Error(kind+" stopped", slog.String(kind, name), slog.String("error", err.Error()))
+
+			m.mutex.Lock()
+			m.errs = append(m.errs, fmt.Errorf("%s %s: %w", kind, name, err))
+			m.mutex.Unlock()
+		}
+	}()
+}
+
+// watch pumps updates from the queue's watcher into the queue.
+func (m *Maestro) watch(ctx context.Context, q *Queue) error {
+	return m.pump(ctx, q.Watcher, q.Apply, slog.String("queue", q.Name))
+}
+
+// pump passes every update from w to apply until w returns. Failed updates are
+// logged with attr. Errors caused by ctx being cancelled are not reported.
+func (m *Maestro) pump(ctx context.Context, w Watcher, apply func(QueueUpdateMessage) error, attr slog.Attr) error {
+	updates := make(chan QueueUpdateMessage)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- w.Watch(ctx, updates)
+	}()
+
+	for {
+		select {
+		case msg := <-updates:
+			if err := apply(msg); err != nil {
+				m.Config.Logger.Error("failed to apply update",
+					attr,
+					slog.String("id", msg.ID),
+					slog.String("error", err.Error()),
+				)
+			}
+		case err := <-done:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// dispatch delivers the queue's items to its subscribers in turn until ctx is
+// cancelled.
+func (m *Maestro) dispatch(ctx context.Context, q *Queue) error {
+	for turn := 0; ; turn++ {
+		peer, err := m.nextSubscriber(ctx, q.Name, turn)
+		if err != nil {
+			return nil
+		}
+
+		d, err := q.Next(ctx, peer.ConnID)
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// the peer may have gone away while we waited for an item
+		if !m.Peers.IsSubscribed(peer.ConnID, q.Name) {
+			_ = q.Release(d.Tag)
+			continue
+		}
+
+		if err := m.deliver(peer, d); err != nil {
+			m.Config.Logger.Error("failed to deliver item",
+				slog.String("queue", q.Name),
+				slog.String("conn_id", peer.ConnID),
+				slog.String("id", d.Item.ID()),
+				slog.String("error", err.Error()),
+			)
+			_ = q.Release(d.Tag)
+		}
+	}
+}
+
+// nextSubscriber picks the queue's subscriber for this turn, waiting for one
+// to subscribe if there are none.
+func (m *Maestro) nextSubscriber(ctx context.Context, queue string, turn int) (*Peer, error) {
+	for {
+		changed := m.Peers.Changed()
+		if subs := m.Peers.Subscribers(queue); len(subs) > 0 {
+			return subs[turn%len(subs)], nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (m *Maestro) deliver(peer *Peer, d *Delivery) error {
+	if peer.Session == nil {
+		return fmt.Errorf("deliver: %w: %s", ErrPeerNotConnected, peer.ConnID)
+	}
+
+	return peer.Session.SendMessage(Message{
+		Content:    d,
+		Auth:       AuthInfo{},
+		ConnID:     peer.ConnID,
+		ActionType: ActionTypeDeliver,
+	})
+}
+
+// sweep requeues deliveries whose visibility timeout has expired.
+func (m *Maestro) sweep(ctx context.Context, q *Queue) error {
+	ticker := time.NewTicker(sweepInterval(q.visibilityTimeout(), q.Cfg.Backoff.Initial))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if n := q.RequeueExpired(now); n > 0 {
+				m.Config.Logger.Info("requeued expired deliveries", slog.String("queue", q.Name), slog.Int("count", n))
+			}
+		}
+	}
+}
+
+// sweepInterval checks a few times per visibility timeout and backoff delay,
+// and at least once a second, so expired items are not left waiting much past
+// their deadline.
+func sweepInterval(timeout time.Duration, backoff time.Duration) time.Duration {
+	interval := min(timeout/4, time.Second)
+	if backoff > 0 {
+		interval = min(interval, backoff/4)
+	}
+	return max(interval, time.Millisecond)
 }
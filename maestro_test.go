@@ -0,0 +1,161 @@
+package maestro_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() maestro.Config {
+	return maestro.Config{
+		Logger: *slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// logBuffer collects log output that tests wait on.
+type logBuffer struct {
+	b     strings.Builder
+	mutex sync.Mutex
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *logBuffer) Contains(s string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return strings.Contains(l.b.String(), s)
+}
+
+func TestMaestro_Queues(t *testing.T) {
+	m := maestro.New(testConfig())
+
+	_, err := m.CreateQueue("b", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+	a, err := m.CreateQueue("a", nil, maestro.NewHeapContainer(maestro.QueueConfig{}), maestro.QueueConfig{})
+	require.NoError(t, err)
+	require.IsType(t, &maestro.SyncContainer{}, a.Container, "container should be wrapped for concurrent use")
+
+	_, err = m.CreateQueue("a", nil, nil, maestro.QueueConfig{})
+	require.ErrorIs(t, err, maestro.ErrQueueExists)
+
+	got, err := m.Queue("a")
+	require.NoError(t, err)
+	require.Same(t, a, got)
+
+	queues := m.Queues()
+	require.Len(t, queues, 2)
+	require.Equal(t, "a", queues[0].Name)
+	require.Equal(t, "b", queues[1].Name)
+
+	require.NoError(t, m.DeleteQueue("a"))
+	require.ErrorIs(t, m.DeleteQueue("a"), maestro.ErrQueueNotFound)
+
+	_, err = m.Queue("a")
+	require.ErrorIs(t, err, maestro.ErrQueueNotFound)
+}
+
+func TestMaestro_Run(t *testing.T) {
+	m := maestro.New(testConfig())
+	w := maestro.NewMemoryWatcher()
+	q, err := m.CreateQueue("test", w, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	w.Insert("a", "one")
+	w.Insert("b", "two")
+	w.Delete("a")
+
+	require.Eventually(t, func() bool {
+		return q.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []maestro.QueueItem{maestro.NewQueueItem("b", "two")}, q.Container.Items())
+
+	// queues created while running are started immediately
+	late := maestro.NewMemoryWatcher()
+	lq, err := m.CreateQueue("late", late, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+	late.Insert("c", "three")
+	require.Eventually(t, func() bool {
+		return lq.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	require.ErrorIs(t, m.Run(ctx), maestro.ErrAlreadyRunning)
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Run did not return after cancel")
+	}
+}
+
+func TestMaestro_RunReportsWatcherErrors(t *testing.T) {
+	logs := &logBuffer{}
+	m := maestro.New(maestro.Config{Logger: *slog.New(slog.NewTextHandler(logs, nil))})
+	errWatch := errors.New("watch failed")
+	w := maestro.NewMemoryWatcher()
+	w.Fail(errWatch)
+	_, err := m.CreateQueue("test", w, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	// cancelling before the watcher has stopped would hide its error
+	require.Eventually(t, func() bool {
+		return logs.Contains("queue stopped")
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		require.ErrorIs(t, err, errWatch)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Run did not return after cancel")
+	}
+}
+
+func TestMaestro_DeleteQueueStopsWatcher(t *testing.T) {
+	m := maestro.New(testConfig())
+	w := maestro.NewMemoryWatcher()
+	_, err := m.CreateQueue("test", w, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = m.Run(ctx)
+	}()
+
+	w.Insert("a", nil)
+	require.Eventually(t, func() bool {
+		return w.Pending() == 0
+	}, time.Second, time.Millisecond)
+	require.NoError(t, m.DeleteQueue("test"))
+
+	w.Insert("b", nil)
+	require.Never(t, func() bool {
+		return w.Pending() == 0
+	}, 50*time.Millisecond, time.Millisecond, "watcher still running after queue was deleted")
+}
@@ -0,0 +1,164 @@
+package maestro
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryWatcher is a Watcher fed from code rather than a database, for tests
+// and for embedding Maestro in a program that produces its own updates.
+//
+// Updates are held in order until Watch sends them, so they can be published
+// before Watch starts. If several Watch calls run at once, each update goes to
+// just one of them.
+type MemoryWatcher struct {
+	pending []memoryEvent
+	// changed is closed and replaced whenever pending or paused change.
+	changed chan struct{}
+	paused  bool
+	mutex   sync.Mutex
+}
+
+// memoryEvent is a published update, or an error Watch should return once
+// the updates before it have been sent.
+type memoryEvent struct {
+	err error
+	msg QueueUpdateMessage
+}
+
+var (
+	_ Watcher         = (*MemoryWatcher)(nil)
+	_ ContainerWriter = (*MemoryWatcher)(nil)
+)
+
+func NewMemoryWatcher() *MemoryWatcher {
+	return &MemoryWatcher{
+		pending: nil,
+		changed: make(chan struct{}),
+		paused:  false,
+		mutex:   sync.Mutex{},
+	}
+}
+
+// Watch sends published updates on c until ctx is cancelled or it reaches an
+// error passed to Fail.
+func (w *MemoryWatcher) Watch(ctx context.Context, c chan QueueUpdateMessage) error {
+	for {
+		ev, ok, changed := w.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-changed:
+			}
+			continue
+		}
+
+		if ev.err != nil {
+			return ev.err
+		}
+
+		select {
+		case c <- ev.msg:
+		case <-ctx.Done():
+			w.putBack(ev)
+			return ctx.Err()
+		case <-changed:
+			// paused while waiting to send
+			w.putBack(ev)
+		}
+	}
+}
+
+// Publish queues msg to be sent by Watch.
+func (w *MemoryWatcher) Publish(msg QueueUpdateMessage) {
+	w.push(memoryEvent{err: nil, msg: msg})
+}
+
+func (w *MemoryWatcher) Insert(id string, data any) {
+	w.Publish(QueueUpdateMessage{OpType: OpTypeInsert, ID: id, Data: data})
+}
+
+func (w *MemoryWatcher) Update(id string, data any) {
+	w.Publish(QueueUpdateMessage{OpType: OpTypeUpdate, ID: id, Data: data})
+}
+
+func (w *MemoryWatcher) Delete(id string) {
+	w.Publish(QueueUpdateMessage{OpType: OpTypeDelete, ID: id})
+}
+
+// Write publishes item as an insert, so a MemoryWatcher can be both a queue's
+// Watcher and its Writer.
+func (w *MemoryWatcher) Write(item QueueItem) error {
+	w.Insert(item.ID(), item.Data())
+	return nil
+}
+
+// Fail makes Watch return err once it has sent the updates published before
+// it, as if the watcher had lost its source. Updates published after it are
+// kept for the next Watch.
+func (w *MemoryWatcher) Fail(err error) {
+	w.push(memoryEvent{err: err, msg: QueueUpdateMessage{}})
+}
+
+// Pause holds back updates and errors until Resume is called.
+func (w *MemoryWatcher) Pause() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.paused = true
+	w.notify()
+}
+
+func (w *MemoryWatcher) Resume() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.paused = false
+	w.notify()
+}
+
+// Pending returns the number of updates and errors Watch has yet to send.
+func (w *MemoryWatcher) Pending() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return len(w.pending)
+}
+
+// next takes the first pending event unless paused, and returns the channel
+// closed on the next change to wait on.
+func (w *MemoryWatcher) next() (memoryEvent, bool, chan struct{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.paused || len(w.pending) == 0 {
+		return memoryEvent{}, false, w.changed
+	}
+
+	ev := w.pending[0]
+	w.pending = w.pending[1:]
+	return ev, true, w.changed
+}
+
+func (w *MemoryWatcher) push(ev memoryEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = append(w.pending, ev)
+	w.notify()
+}
+
+// putBack returns an event Watch could not send to the front of pending.
+func (w *MemoryWatcher) putBack(ev memoryEvent) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.pending = append([]memoryEvent{ev}, w.pending...)
+}
+
+// notify wakes every Watch waiting on changed. The caller must hold the lock.
+func (w *MemoryWatcher) notify() {
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
@@ -0,0 +1,109 @@
+package maestro_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+// startWatch runs w.Watch in the background until the test ends, returning its
+// updates and the error it returns.
+func startWatch(t *testing.T, w maestro.Watcher) (chan maestro.QueueUpdateMessage, chan error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan maestro.QueueUpdateMessage, 16)
+	done := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		done <- w.Watch(ctx, c)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	return c, done
+}
+
+func receive(t *testing.T, c chan maestro.QueueUpdateMessage) maestro.QueueUpdateMessage {
+	t.Helper()
+
+	select {
+	case msg := <-c:
+		return msg
+	case <-time.After(time.Second):
+		require.FailNow(t, "no update received")
+		return maestro.QueueUpdateMessage{}
+	}
+}
+
+func TestMemoryWatcher_Watch(t *testing.T) {
+	w := maestro.NewMemoryWatcher()
+
+	// published before Watch starts
+	w.Insert("a", "one")
+	w.Update("a", "two")
+
+	c, _ := startWatch(t, w)
+	w.Delete("a")
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: "b", Namespace: "orders"})
+
+	require.Equal(t, maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: "a", Data: "one"}, receive(t, c))
+	require.Equal(t, maestro.QueueUpdateMessage{OpType: maestro.OpTypeUpdate, ID: "a", Data: "two"}, receive(t, c))
+	require.Equal(t, maestro.QueueUpdateMessage{OpType: maestro.OpTypeDelete, ID: "a"}, receive(t, c))
+	require.Equal(t, "orders", receive(t, c).Namespace)
+	require.Zero(t, w.Pending())
+}
+
+func TestMemoryWatcher_Pause(t *testing.T) {
+	w := maestro.NewMemoryWatcher()
+	c, _ := startWatch(t, w)
+
+	w.Pause()
+	w.Insert("a", nil)
+	select {
+	case <-c:
+		require.FailNow(t, "update sent while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	require.Equal(t, 1, w.Pending())
+
+	w.Resume()
+	require.Equal(t, "a", receive(t, c).ID)
+}
+
+func TestMemoryWatcher_Fail(t *testing.T) {
+	w := maestro.NewMemoryWatcher()
+	errLost := errors.New("lost")
+
+	w.Insert("a", nil)
+	w.Fail(errLost)
+	w.Insert("b", nil)
+
+	c, done := startWatch(t, w)
+	require.Equal(t, "a", receive(t, c).ID)
+	require.ErrorIs(t, <-done, errLost)
+
+	// updates after the error are left for the next Watch
+	c, _ = startWatch(t, w)
+	require.Equal(t, "b", receive(t, c).ID)
+}
+
+func TestMemoryWatcher_CancelKeepsUnsentUpdate(t *testing.T) {
+	w := maestro.NewMemoryWatcher()
+	w.Insert("a", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// nobody reads c, so the update is never sent
+	err := w.Watch(ctx, make(chan maestro.QueueUpdateMessage))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 1, w.Pending())
+}
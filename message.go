@@ -1,14 +1,141 @@
 package maestro
 
+import "errors"
+
 type ActionType string
 
 const (
 	ActionTypeAcknowledge ActionType = "acknowledge"
+	ActionTypeNack        ActionType = "nack"
 	ActionTypeSubscribe   ActionType = "subscribe"
+	ActionTypeUnsubscribe ActionType = "unsubscribe"
+	ActionTypePublish     ActionType = "publish"
+	// ActionTypeDeliver is sent to a subscriber with a *Delivery as content.
+	ActionTypeDeliver ActionType = "deliver"
+	// ActionTypePublished is sent to a publisher with a *Published as content
+	// once its item has been written.
+	ActionTypePublished ActionType = "published"
+	// ActionTypeSubscribed is sent to a subscriber with a *Subscribed as
+	// content.
+	ActionTypeSubscribed ActionType = "subscribed"
+	// ActionTypeError is sent to a peer with an *ErrorReply as content when
+	// one of its messages fails.
+	ActionTypeError ActionType = "error"
 )
 
 type Message struct {
 	Content    interface{}
+	Auth       AuthInfo
 	ConnID     string
 	ActionType ActionType
 }
+
+// DeliveryRef is implemented by message content that refers to a delivery,
+// such as pb.Ack.
+type DeliveryRef interface {
+	QueueRef
+	GetDeliveryTag() string
+}
+
+// NackRef is implemented by message content that rejects a delivery, such as
+// pb.Nack.
+type NackRef interface {
+	DeliveryRef
+	GetReason() string
+	GetRequeue() bool
+}
+
+// PublishRef is implemented by message content that publishes an item, such as
+// pb.Publish.
+type PublishRef interface {
+	QueueRef
+	RequestRef
+	GetID() string
+	GetData() []byte
+}
+
+// RequestRef is implemented by message content that carries a request ID for
+// the reply to echo, such as pb.Subscribe.
+type RequestRef interface {
+	GetRequestID() string
+}
+
+// Published confirms an item was written to a queue's backing store.
+type Published struct {
+	RequestID string
+	Queue     string
+	ID        string
+}
+
+// Subscribed confirms a subscription.
+type Subscribed struct {
+	RequestID string
+	Queue     string
+}
+
+// ErrorCode classifies a failed request for the peer that sent it. The values
+// match pb.ErrorCode.
+type ErrorCode int32
+
+const (
+	ErrorCodeUnknown ErrorCode = iota
+	ErrorCodeUnauthorized
+	ErrorCodeInvalidMessage
+	ErrorCodeUnsupportedAction
+	ErrorCodeQueueNotFound
+	ErrorCodeDeliveryNotFound
+	ErrorCodeNotSubscribed
+	ErrorCodeNoWriter
+)
+
+// ErrorReply tells a peer that a request failed.
+type ErrorReply struct {
+	RequestID string
+	Message   string
+	Code      ErrorCode
+}
+
+// errorCodes maps the errors peers can be told about to their codes.
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrUnauthorized, ErrorCodeUnauthorized},
+	{ErrInvalidContent, ErrorCodeInvalidMessage},
+	{ErrUnsupportedAction, ErrorCodeUnsupportedAction},
+	{ErrQueueNotFound, ErrorCodeQueueNotFound},
+	{ErrDeliveryNotFound, ErrorCodeDeliveryNotFound},
+	{ErrNotSubscribed, ErrorCodeNotSubscribed},
+	{ErrNoWriter, ErrorCodeNoWriter},
+}
+
+// NewErrorReply describes err to the peer whose request requestID failed.
+func NewErrorReply(requestID string, err error) *ErrorReply {
+	reply := &ErrorReply{
+		RequestID: requestID,
+		Message:   err.Error(),
+		Code:      ErrorCodeUnknown,
+	}
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			reply.Code = ec.code
+			break
+		}
+	}
+
+	return reply
+}
+
+// requestID returns the request ID carried by content, if any.
+func requestID(content any) string {
+	if ref, ok := content.(RequestRef); ok {
+		return ref.GetRequestID()
+	}
+	return ""
+}
+
+// QueueRef is implemented by message content that targets a queue, such as
+// pb.Subscribe and pb.Unsubscribe.
+type QueueRef interface {
+	GetQueue() string
+}
@@ -2,41 +2,144 @@ package maestro
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 type MongoWatcher struct {
-	client     *mongo.Client
-	database   *mongo.Database
+	client   *mongo.Client
+	database *mongo.Database
+	// collection is set when a single collection is watched.
 	collection *mongo.Collection
 	opts       MongoWatcherOpts
 }
 
 type MongoWatcherOpts struct {
-	DatabaseName   string
-	CollectionName string
+	// TokenStore saves the resume token of every change handled so Watch
+	// resumes from the last one after a restart. Changes made while the broker
+	// is down are missed when it is nil.
+	TokenStore   TokenStore
+	DatabaseName string
+	// CollectionName and CollectionNames name the collections to watch from a
+	// single change stream. The whole database is watched when both are empty.
+	// Use a Maestro router to send each collection's changes to its own queue.
+	CollectionName  string
+	CollectionNames []string
+	// ResumeKey names this watcher's token in TokenStore. Defaults to
+	// "<DatabaseName>.<CollectionName>", with the collection names joined by
+	// commas when there are several, or just the database name when watching
+	// the whole database.
+	ResumeKey string
+	// OnStateChange is called whenever the change stream connects, drops or
+	// stops. err is the reason the stream was lost or stopped, if any.
+	OnStateChange func(state WatcherState, err error)
+	// OnUndecodableChange is called with change events that are skipped
+	// because they can't be decoded. err wraps ErrUndecodableChange.
+	OnUndecodableChange func(event bson.Raw, err error)
+	// Reconnect is the backoff between attempts to reopen a failed change
+	// stream. DefaultReconnect is used when Initial is 0. A random jitter
+	// of up to half the delay is taken off each wait.
+	Reconnect Backoff
+	// Filter only lets through changes to documents that match it, along with
+	// every delete. Keys are field paths and values are either the value to
+	// match or an operator document such as {"$in": ["ready", "retry"]}, as in
+	// a find filter, so it can be written in a config file. It is matched
+	// against the full document, so it needs FullDocument to look documents
+	// up. Documents updated so they no longer match are not removed from the
+	// queue.
+	Filter bson.M
+	// Pipeline is appended to the change stream's pipeline, after Filter. It
+	// runs on the server, so it can $match events or $project away fields that
+	// consumers don't need. Stages must keep the event's _id, which is its
+	// resume token.
+	Pipeline mongo.Pipeline
+	// FullDocument sets whether update events carry the whole document as
+	// QueueUpdateMessage.Data. Defaults to options.UpdateLookup, as an update
+	// replaces the queued item with its Data. With options.Default, updates
+	// only carry their UpdateDescription and their Data is nil. Inserts and
+	// replaces always carry the document.
+	FullDocument options.FullDocument
+	// FullDocumentBeforeChange sets whether update, replace and delete events
+	// carry the document as it was before the change as
+	// QueueUpdateMessage.Before. The collection needs
+	// changeStreamPreAndPostImages enabled.
+	FullDocumentBeforeChange options.FullDocument
+	// ResumeAfter resumes with resumeAfter rather than startAfter, for servers
+	// older than 4.2. Unlike startAfter it can't resume past an invalidate.
+	ResumeAfter bool
+	// Bootstrap sends the documents already in the collection that match
+	// Filter as inserts, in _id order, before watching for changes. It only
+	// happens when there is no resume token to pick up from.
+	Bootstrap bool
 }
 
+var (
+	ErrUndecodableChange = errors.New("undecodable change event")
+	ErrNoOperationTime   = errors.New("server did not return an operation time, bootstrap needs a replica set")
+	errStreamClosed      = errors.New("change stream closed")
+)
+
+// Server error codes Watch treats specially.
+const (
+	mongoBadValue                = 2
+	mongoFailedToParse           = 9
+	mongoUnauthorized            = 13
+	mongoAuthenticationFailed    = 18
+	mongoChangeStreamFatalError  = 280
+	mongoChangeStreamHistoryLost = 286
+	mongoUnrecognizedStage       = 40324
+	mongoReplicaSetRequired      = 40573
+)
+
 type MongoChangeEvent struct {
-	FullDocument  bson.M             `bson:"fullDocument"`
-	OperationType string             `bson:"operationType"`
-	DocumentKey   primitive.ObjectID `bson:"documentKey"`
+	Namespace                MongoNamespace          `bson:"ns"`
+	FullDocument             bson.M                  `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.M                  `bson:"fullDocumentBeforeChange"`
+	UpdateDescription        *MongoUpdateDescription `bson:"updateDescription"`
+	OperationType            string                  `bson:"operationType"`
+	// DocumentKey holds the document's _id, along with its shard key on
+	// sharded collections.
+	DocumentKey bson.Raw `bson:"documentKey"`
+}
+
+type MongoNamespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+type MongoUpdateDescription struct {
+	UpdatedFields bson.M   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
 }
 
 func NewMongoWatcher(client *mongo.Client, opts MongoWatcherOpts) (*MongoWatcher, error) {
 	if opts.DatabaseName == "" {
 		return nil, errors.New("database name is required")
-	} else if opts.CollectionName == "" {
-		return nil, errors.New("collection name is required")
 	}
 
+	names := slices.Clone(opts.CollectionNames)
+	if opts.CollectionName != "" {
+		names = append(names, opts.CollectionName)
+	}
+	slices.Sort(names)
+	opts.CollectionNames = slices.Compact(names)
+	opts.CollectionName = ""
+
 	mw := &MongoWatcher{
 		client:     client,
 		opts:       opts,
@@ -45,50 +148,766 @@ func NewMongoWatcher(client *mongo.Client, opts MongoWatcherOpts) (*MongoWatcher
 	}
 
 	mw.database = client.Database(mw.opts.DatabaseName)
-	mw.collection = mw.database.Collection(mw.opts.CollectionName)
+	if len(mw.opts.CollectionNames) == 1 {
+		mw.collection = mw.database.Collection(mw.opts.CollectionNames[0])
+	}
+	if mw.opts.ResumeKey == "" {
+		mw.opts.ResumeKey = mw.opts.DatabaseName
+		if len(mw.opts.CollectionNames) > 0 {
+			mw.opts.ResumeKey += "." + strings.Join(mw.opts.CollectionNames, ",")
+		}
+	}
+	if mw.opts.FullDocument == "" {
+		mw.opts.FullDocument = options.UpdateLookup
+	}
 
 	return mw, nil
 }
 
+// Watch sends every change to the collection on c until ctx is cancelled. With
+// a TokenStore it resumes after the last change it handled, and if that change
+// has aged out of the oplog it starts a new stream and sends the whole
+// collection as updates instead. With Bootstrap and no token to resume from, it
+// first sends the collection as inserts.
+//
+// A stream that fails or closes is reopened from the last change seen, waiting
+// longer between each attempt as set by Reconnect. Watch only returns early on
+// errors that reconnecting won't fix, such as bad credentials or an invalid
+// pipeline. Events that can't be decoded are skipped and passed to
+// OnUndecodableChange.
 func (mw *MongoWatcher) Watch(ctx context.Context, c chan QueueUpdateMessage) error {
-	watcher, err := mw.collection.Watch(ctx, mongo.Pipeline{})
+	token, err := mw.loadToken(ctx)
 	if err != nil {
 		return err
 	}
 
+	backoff := mw.opts.Reconnect
+	if backoff.Initial <= 0 {
+		backoff = DefaultReconnect
+	}
+
+	st := &mongoStreamState{
+		token:     token,
+		startAt:   nil,
+		attempt:   0,
+		bootstrap: mw.opts.Bootstrap && token == nil,
+	}
+
+	mw.setState(WatcherStateConnecting, nil)
 	for {
+		err := mw.stream(ctx, c, st)
+		if ctx.Err() != nil {
+			mw.setState(WatcherStateStopped, nil)
+			return ctx.Err()
+		} else if !isRetryable(err) {
+			mw.setState(WatcherStateStopped, err)
+			return err
+		}
+
+		st.attempt++
+		mw.setState(WatcherStateReconnecting, err)
+
+		timer := time.NewTimer(jitter(backoff.Delay(st.attempt)))
 		select {
 		case <-ctx.Done():
+			timer.Stop()
+			mw.setState(WatcherStateStopped, nil)
 			return ctx.Err()
-		default:
-			if watcher.Next(ctx) {
-				var data MongoChangeEvent
-				err := watcher.Decode(&data)
-				if err != nil {
-					return err
-				}
-				var op OpType
-				switch data.OperationType {
-				case "insert":
-					op = OpTypeInsert
-				case "update":
-					op = OpTypeUpdate
-				case "delete":
-					op = OpTypeDelete
-				default:
-					continue
-				}
-
-				msg := QueueUpdateMessage{
-					OpType: op,
-					ID:     data.DocumentKey.Hex(),
-					Data:   data.FullDocument,
-				}
-
-				c <- msg
+		case <-timer.C:
+		}
+	}
+}
+
+// mongoStreamState is carried by Watch from one change stream to the next.
+type mongoStreamState struct {
+	// token is the resume token of the last change seen.
+	token bson.Raw
+	// startAt is where a stream without a token starts, once bootstrapped.
+	startAt *primitive.Timestamp
+	// attempt counts the reconnects since a change last came through.
+	attempt int
+	// bootstrap is set until the collection has been bootstrapped.
+	bootstrap bool
+}
+
+// stream opens a change stream from st and sends its changes on c until it
+// fails, keeping st.token at the last change seen.
+func (mw *MongoWatcher) stream(ctx context.Context, c chan QueueUpdateMessage, st *mongoStreamState) error {
+	if st.bootstrap {
+		startAt, err := mw.bootstrap(ctx, c)
+		if err != nil {
+			return err
+		}
+		st.startAt = &startAt
+		st.bootstrap = false
+	}
+
+	stream, err := mw.open(ctx, st.token, st.startAt)
+	if isHistoryLost(err) {
+		st.startAt = nil
+		stream, err = mw.resync(ctx, c)
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.WithoutCancel(ctx))
+
+	if t := stream.ResumeToken(); t != nil {
+		st.token = t
+	}
+	mw.setState(WatcherStateWatching, nil)
+
+	for stream.Next(ctx) {
+		st.attempt = 0
+
+		var data MongoChangeEvent
+		if err := stream.Decode(&data); err != nil {
+			// skip the event rather than stop the queue over it
+			if mw.opts.OnUndecodableChange != nil {
+				mw.opts.OnUndecodableChange(slices.Clone(stream.Current), fmt.Errorf("%w: %w", ErrUndecodableChange, err))
+			}
+		} else if msg, ok := data.updateMessage(); ok {
+			if err := send(ctx, c, msg); err != nil {
+				return err
 			}
 		}
+
+		st.token = stream.ResumeToken()
+		if err := mw.saveToken(ctx, st.token); err != nil {
+			return err
+		}
+	}
+
+	if t := stream.ResumeToken(); t != nil {
+		st.token = t
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+
+	return errStreamClosed
+}
+
+func (mw *MongoWatcher) setState(state WatcherState, err error) {
+	if mw.opts.OnStateChange != nil {
+		mw.opts.OnStateChange(state, err)
+	}
+}
+
+// open starts a change stream, resuming after token if it is set or else
+// starting at startAt if that is set.
+func (mw *MongoWatcher) open(ctx context.Context, token bson.Raw, startAt *primitive.Timestamp) (*mongo.ChangeStream, error) {
+	opts := options.ChangeStream()
+	if mw.opts.FullDocument != "" {
+		opts.SetFullDocument(mw.opts.FullDocument)
+	}
+	if mw.opts.FullDocumentBeforeChange != "" {
+		opts.SetFullDocumentBeforeChange(mw.opts.FullDocumentBeforeChange)
+	}
+	if token != nil {
+		if mw.opts.ResumeAfter {
+			opts.SetResumeAfter(token)
+		} else {
+			opts.SetStartAfter(token)
+		}
+	} else if startAt != nil {
+		opts.SetStartAtOperationTime(startAt)
+	}
+
+	if mw.collection != nil {
+		return mw.collection.Watch(ctx, mw.pipeline(), opts)
+	}
+	return mw.database.Watch(ctx, mw.pipeline(), opts)
+}
+
+// pipeline builds the change stream pipeline from the watched collections,
+// Filter and Pipeline.
+func (mw *MongoWatcher) pipeline() mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(mw.opts.CollectionNames) > 1 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: mw.opts.CollectionNames}}},
+		}}})
+	}
+	if mw.opts.Filter != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "operationType", Value: "delete"}},
+			prefixFields(mw.opts.Filter, "fullDocument."),
+		}}}}})
+	}
+
+	return append(pipeline, mw.opts.Pipeline...)
+}
+
+// filter returns Filter as a find filter for scanning the collection.
+func (mw *MongoWatcher) filter() bson.D {
+	if mw.opts.Filter == nil {
+		return bson.D{}
+	}
+	return prefixFields(mw.opts.Filter, "")
+}
+
+// prefixFields prefixes every field path in filter, including those nested in
+// $and, $or and $nor, and orders the fields so the same filter always builds
+// the same query.
+func prefixFields(filter bson.M, prefix string) bson.D {
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	d := make(bson.D, 0, len(filter))
+	for _, key := range keys {
+		value := filter[key]
+		if !strings.HasPrefix(key, "$") {
+			d = append(d, bson.E{Key: prefix + key, Value: value})
+			continue
+		}
+
+		// logical operators hold a list of filters
+		switch clauses := value.(type) {
+		case []any:
+			value = prefixClauses(clauses, prefix)
+		case bson.A:
+			value = prefixClauses(clauses, prefix)
+		}
+		d = append(d, bson.E{Key: key, Value: value})
+	}
+
+	return d
+}
+
+func prefixClauses(clauses []any, prefix string) bson.A {
+	prefixed := make(bson.A, 0, len(clauses))
+	for _, clause := range clauses {
+		switch c := clause.(type) {
+		case map[string]any:
+			prefixed = append(prefixed, prefixFields(c, prefix))
+		case bson.M:
+			prefixed = append(prefixed, prefixFields(c, prefix))
+		default:
+			prefixed = append(prefixed, clause)
+		}
+	}
+
+	return prefixed
+}
+
+// resync starts a change stream from now and then sends every document in the
+// collection that matches Filter as an update. The stream is opened first so
+// no change made during the scan is missed; the few that are sent twice are
+// harmless because queues apply updates as upserts.
+func (mw *MongoWatcher) resync(ctx context.Context, c chan QueueUpdateMessage) (*mongo.ChangeStream, error) {
+	stream, err := mw.open(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mw.scan(ctx, c, OpTypeUpdate); err != nil {
+		stream.Close(context.WithoutCancel(ctx))
+		return nil, fmt.Errorf("resync: %w", err)
+	}
+
+	if err := mw.saveToken(ctx, stream.ResumeToken()); err != nil {
+		stream.Close(context.WithoutCancel(ctx))
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// bootstrap sends every document in the collection that matches Filter as an
+// insert, and returns the operation time from just before it started so the
+// change stream can pick up from there. Documents changed during the scan may
+// be sent twice, which is harmless because queues apply them as upserts.
+func (mw *MongoWatcher) bootstrap(ctx context.Context, c chan QueueUpdateMessage) (primitive.Timestamp, error) {
+	res, err := mw.database.RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Raw()
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("bootstrap: %w", err)
+	}
+
+	t, i, ok := res.Lookup("operationTime").TimestampOK()
+	if !ok {
+		return primitive.Timestamp{}, fmt.Errorf("bootstrap: %w", ErrNoOperationTime)
+	}
+
+	if err := mw.scan(ctx, c, OpTypeInsert); err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("bootstrap: %w", err)
+	}
+
+	return primitive.Timestamp{T: t, I: i}, nil
+}
+
+// scan sends every matching document in the watched collections as op, in _id
+// order.
+func (mw *MongoWatcher) scan(ctx context.Context, c chan QueueUpdateMessage, op OpType) error {
+	names := mw.opts.CollectionNames
+	if len(names) == 0 {
+		var err error
+		names, err = mw.database.ListCollectionNames(ctx, bson.D{{Key: "type", Value: "collection"}})
+		if err != nil {
+			return err
+		}
+		slices.Sort(names)
+	}
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		if err := mw.scanCollection(ctx, c, name, op); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (mw *MongoWatcher) scanCollection(ctx context.Context, c chan QueueUpdateMessage, name string, op OpType) error {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := mw.database.Collection(name).Find(ctx, mw.filter(), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+
+		msg := QueueUpdateMessage{
+			OpType:    op,
+			ID:        MongoDocumentID(cursor.Current.Lookup("_id")),
+			Namespace: name,
+			Data:      doc,
+		}
+		if err := send(ctx, c, msg); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (mw *MongoWatcher) loadToken(ctx context.Context) (bson.Raw, error) {
+	if mw.opts.TokenStore == nil {
+		return nil, nil
+	}
+
+	token, err := mw.opts.TokenStore.LoadToken(ctx, mw.opts.ResumeKey)
+	if err != nil {
+		return nil, fmt.Errorf("load resume token: %w", err)
+	}
+
+	return token, nil
+}
+
+func (mw *MongoWatcher) saveToken(ctx context.Context, token bson.Raw) error {
+	if mw.opts.TokenStore == nil || token == nil {
+		return nil
+	}
+
+	if err := mw.opts.TokenStore.SaveToken(ctx, mw.opts.ResumeKey, token); err != nil {
+		return fmt.Errorf("save resume token: %w", err)
+	}
+
+	return nil
+}
+
+// updateMessage converts the event to a queue update. Replacing a document is
+// treated as an update. It reports false for operation types that don't change
+// a document.
+func (e MongoChangeEvent) updateMessage() (QueueUpdateMessage, bool) {
+	var op OpType
+	switch e.OperationType {
+	case "insert":
+		op = OpTypeInsert
+	case "update", "replace":
+		op = OpTypeUpdate
+	case "delete":
+		op = OpTypeDelete
+	default:
+		return QueueUpdateMessage{}, false
+	}
+
+	msg := QueueUpdateMessage{
+		Data:              e.FullDocument,
+		Before:            nil,
+		UpdateDescription: nil,
+		ID:                MongoDocumentID(e.DocumentKey.Lookup("_id")),
+		Namespace:         e.Namespace.Collection,
+		OpType:            op,
+	}
+	if e.FullDocumentBeforeChange != nil {
+		msg.Before = e.FullDocumentBeforeChange
+	}
+	if e.UpdateDescription != nil {
+		msg.UpdateDescription = &UpdateDescription{
+			UpdatedFields: e.UpdateDescription.UpdatedFields,
+			RemovedFields: e.UpdateDescription.RemovedFields,
+		}
+	}
+
+	return msg, true
+}
+
+// Prefixes MongoDocumentID puts on IDs that aren't ObjectIDs or plain strings,
+// so that no two _id values share an ID.
+const (
+	mongoIDInt    = "int:"
+	mongoIDUUID   = "uuid:"
+	mongoIDJSON   = "json:"
+	mongoIDString = "string:"
+)
+
+// MongoDocumentID encodes a document's _id as a queue item ID. ObjectIDs are
+// encoded as hex and strings as they are. Integers are encoded in decimal
+// after "int:", UUIDs in their usual hyphenated form after "uuid:" and any
+// other type, such as a compound key, as extended JSON after "json:". Strings
+// that would read as one of those, such as an ObjectID in hex, are prefixed
+// with "string:". Distinct _id values therefore never share an ID, except
+// that 32 and 64-bit integers of the same value do, as they do in _id
+// indexes.
+func MongoDocumentID(id bson.RawValue) string {
+	//nolint:exhaustive // every other type is encoded as extended JSON
+	switch id.Type {
+	case bson.TypeObjectID:
+		return id.ObjectID().Hex()
+	case bson.TypeString:
+		s := id.StringValue()
+		if ambiguousMongoID(s) {
+			return mongoIDString + s
+		}
+		return s
+	case bson.TypeInt32:
+		return mongoIDInt + strconv.FormatInt(int64(id.Int32()), 10)
+	case bson.TypeInt64:
+		return mongoIDInt + strconv.FormatInt(id.Int64(), 10)
+	case bson.TypeBinary:
+		subtype, data := id.Binary()
+		if subtype == bson.TypeBinaryUUID && len(data) == 16 {
+			return mongoIDUUID + fmt.Sprintf("%x-%x-%x-%x-%x", data[0:4], data[4:6], data[6:8], data[8:10], data[10:16])
+		}
+	}
+
+	if len(id.Value) == 0 {
+		return ""
+	}
+	return mongoIDJSON + id.String()
+}
+
+// ambiguousMongoID reports whether the string _id s could be mistaken for the
+// ID of an _id of another type.
+func ambiguousMongoID(s string) bool {
+	if s == "" || isObjectIDHex(s) {
+		return true
+	}
+
+	for _, prefix := range []string{mongoIDInt, mongoIDUUID, mongoIDJSON, mongoIDString} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isObjectIDHex(s string) bool {
+	oid, err := primitive.ObjectIDFromHex(s)
+	return err == nil && oid.Hex() == s
+}
+
+// mongoIDValue reverses MongoDocumentID, returning the _id an item ID stands
+// for. IDs it didn't produce are taken as string _ids.
+func mongoIDValue(id string) any {
+	if isObjectIDHex(id) {
+		oid, _ := primitive.ObjectIDFromHex(id)
+		return oid
+	}
+
+	switch {
+	case strings.HasPrefix(id, mongoIDString):
+		return strings.TrimPrefix(id, mongoIDString)
+	case strings.HasPrefix(id, mongoIDInt):
+		n, err := strconv.ParseInt(strings.TrimPrefix(id, mongoIDInt), 10, 64)
+		if err != nil {
+			return id
+		}
+		if n >= math.MinInt32 && n <= math.MaxInt32 {
+			return int32(n)
+		}
+		return n
+	case strings.HasPrefix(id, mongoIDUUID):
+		data, err := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(id, mongoIDUUID), "-", ""))
+		if err != nil || len(data) != 16 {
+			return id
+		}
+		return primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: data}
+	case strings.HasPrefix(id, mongoIDJSON):
+		var doc bson.Raw
+		if err := bson.UnmarshalExtJSON([]byte(`{"v":`+strings.TrimPrefix(id, mongoIDJSON)+`}`), true, &doc); err != nil {
+			return id
+		}
+		return doc.Lookup("v")
+	default:
+		return id
+	}
+}
+
+func send(ctx context.Context, c chan QueueUpdateMessage, msg QueueUpdateMessage) error {
+	select {
+	case c <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isHistoryLost reports whether err means the resume token is no longer in
+// the oplog, so the stream can't be resumed from it.
+func isHistoryLost(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	return se.HasErrorCode(mongoChangeStreamHistoryLost) ||
+		se.HasErrorCodeWithMessage(mongoChangeStreamFatalError, "no longer be in the oplog")
+}
+
+// isRetryable reports whether reopening the change stream might get past err.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrNoOperationTime) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return false
+	}
+
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		return !se.HasErrorCode(mongoBadValue) &&
+			!se.HasErrorCode(mongoFailedToParse) &&
+			!se.HasErrorCode(mongoUnauthorized) &&
+			!se.HasErrorCode(mongoAuthenticationFailed) &&
+			!se.HasErrorCode(mongoChangeStreamFatalError) &&
+			!se.HasErrorCode(mongoUnrecognizedStage) &&
+			!se.HasErrorCode(mongoReplicaSetRequired)
+	}
+
+	return true
+}
+
+// jitter takes a random amount of up to half of d off it, so watchers that
+// lost their connection together don't all retry at once.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d - rand.N(d/2) //nolint:gosec // jitter doesn't need a secure source
+}
+
+// MongoTokenStore keeps resume tokens in a Mongo collection, one document per
+// key.
+type MongoTokenStore struct {
+	collection *mongo.Collection
+}
+
+type mongoResumeToken struct {
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Key       string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+}
+
+var _ TokenStore = (*MongoTokenStore)(nil)
+
+func NewMongoTokenStore(collection *mongo.Collection) *MongoTokenStore {
+	return &MongoTokenStore{
+		collection: collection,
+	}
+}
+
+func (ts *MongoTokenStore) LoadToken(ctx context.Context, key string) (bson.Raw, error) {
+	var doc mongoResumeToken
+	err := ts.collection.FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return doc.Token, nil
+}
+
+func (ts *MongoTokenStore) SaveToken(ctx context.Context, key string, token bson.Raw) error {
+	doc := mongoResumeToken{
+		UpdatedAt: time.Now(),
+		Key:       key,
+		Token:     token,
+	}
+
+	_, err := ts.collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: key}}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// MongoWriter is a ContainerWriter that inserts items into the collection a
+// MongoWatcher watches, so they reach the queue through its change stream.
+type MongoWriter struct {
+	collection *mongo.Collection
+	opts       MongoWriterOpts
+}
+
+type MongoWriterOpts struct {
+	// WriteConcern is how durable a write must be before it is confirmed.
+	// Defaults to a majority of the replica set, so a confirmed publish
+	// survives a failover.
+	WriteConcern   *writeconcern.WriteConcern
+	DatabaseName   string
+	CollectionName string
+	// Timeout bounds each write. Defaults to DefaultMongoWriteTimeout.
+	Timeout time.Duration
+}
+
+const DefaultMongoWriteTimeout = 10 * time.Second
+
+// mongoDuplicateKey is the server error code for a value that already exists
+// in a unique index.
+const mongoDuplicateKey = 11000
+
+var _ ContainerWriter = (*MongoWriter)(nil)
+
+func NewMongoWriter(client *mongo.Client, opts MongoWriterOpts) (*MongoWriter, error) {
+	if opts.DatabaseName == "" || opts.CollectionName == "" {
+		return nil, errors.New("database and collection names are required")
+	}
+	if opts.WriteConcern == nil {
+		opts.WriteConcern = writeconcern.Majority()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultMongoWriteTimeout
+	}
+
+	coll := client.Database(opts.DatabaseName).Collection(opts.CollectionName,
+		options.Collection().SetWriteConcern(opts.WriteConcern))
+
+	return &MongoWriter{
+		collection: coll,
+		opts:       opts,
+	}, nil
+}
+
+// Write inserts item with its ID as the _id. Writing an item whose ID already
+// exists succeeds without changing it, so a publisher can safely retry. A clash
+// on any other unique index of the collection is still an error.
+func (mw *MongoWriter) Write(item QueueItem) error {
+	return mw.WriteMany([]QueueItem{item})
+}
+
+// WriteMany inserts items in a single bulk write. Items whose ID already exists
+// are skipped as in Write, and the others are inserted even if some fail.
+func (mw *MongoWriter) WriteMany(items []QueueItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	docs := make([]any, 0, len(items))
+	for _, item := range items {
+		doc, err := mongoItemDocument(item)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", item.ID(), err)
+		}
+		docs = append(docs, doc)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mw.opts.Timeout)
+	defer cancel()
+
+	_, err := mw.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if onlyDuplicateKeys(err) {
+		return nil
+	}
+	return err
+}
+
+// onlyDuplicateKeys reports whether err is a bulk write where every failure was
+// an _id that already exists.
+func onlyDuplicateKeys(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return false
+	}
+
+	for _, we := range bwe.WriteErrors {
+		if !isDuplicateID(we.WriteError) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDuplicateID reports whether we is a duplicate key error on the _id index,
+// as opposed to another unique index of the collection.
+func isDuplicateID(we mongo.WriteError) bool {
+	if we.Code != mongoDuplicateKey {
+		return false
+	}
+
+	if kp, err := we.Raw.LookupErr("keyPattern"); err == nil {
+		doc, ok := kp.DocumentOK()
+		if !ok {
+			return false
+		}
+		keys, err := doc.Elements()
+		return err == nil && len(keys) == 1 && keys[0].Key() == "_id"
+	}
+
+	// servers that don't send keyPattern only name the index in the message
+	return strings.Contains(we.Message, " index: _id_ ")
+}
+
+// mongoItemDocument converts item to the document to insert. Documents, and
+// bytes or strings holding a JSON object as sent by Publish, are inserted as
+// they are. Anything else is stored under "data". The _id is decoded from the
+// item's ID, so MongoDocumentID gives back the same ID.
+func mongoItemDocument(item QueueItem) (bson.D, error) {
+	doc := bson.D{{Key: "_id", Value: mongoIDValue(item.ID())}}
+
+	var raw bson.Raw
+	switch data := item.Data().(type) {
+	case nil:
+		return doc, nil
+	case []byte:
+		raw = jsonDocument(data)
+	case string:
+		raw = jsonDocument([]byte(data))
+	default:
+		if b, err := bson.Marshal(data); err == nil {
+			raw = b
+		}
+	}
+	if raw == nil {
+		return append(doc, bson.E{Key: "data", Value: item.Data()}), nil
+	}
+
+	elems, err := raw.Elements()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range elems {
+		if e.Key() != "_id" {
+			doc = append(doc, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+	}
+
+	return doc, nil
+}
+
+// jsonDocument parses data as a JSON object, returning nil if it isn't one.
+func jsonDocument(data []byte) bson.Raw {
+	var doc bson.Raw
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil
 	}
+	return doc
 }
 
 // MongoAuthURL constructs a MongoDB connection string from environment variables.
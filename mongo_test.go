@@ -0,0 +1,941 @@
+package maestro_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// memoryTokenStore is a TokenStore that keeps tokens in a map.
+type memoryTokenStore struct {
+	tokens map[string]bson.Raw
+	mutex  sync.Mutex
+}
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{
+		tokens: make(map[string]bson.Raw),
+	}
+}
+
+func (s *memoryTokenStore) LoadToken(_ context.Context, key string) (bson.Raw, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.tokens[key], nil
+}
+
+func (s *memoryTokenStore) SaveToken(_ context.Context, key string, token bson.Raw) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens[key] = token
+	return nil
+}
+
+type mockWatchResult struct {
+	err    error
+	msgs   []maestro.QueueUpdateMessage
+	states []maestro.WatcherState
+	causes []error
+}
+
+// mockWatch runs a MongoWatcher against the mocked deployment until it has lost
+// its change stream reconnects times, and returns what it sent and reported.
+// It watches the test's collection unless opts names a database.
+func mockWatch(mt *mtest.T, opts maestro.MongoWatcherOpts, reconnects int) mockWatchResult {
+	mt.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := mockWatchResult{}
+	if opts.DatabaseName == "" {
+		opts.DatabaseName = mt.Coll.Database().Name()
+		opts.CollectionName = mt.Coll.Name()
+	}
+	opts.Reconnect = maestro.Backoff{Initial: time.Millisecond}
+	opts.OnStateChange = func(state maestro.WatcherState, err error) {
+		res.states = append(res.states, state)
+		res.causes = append(res.causes, err)
+		if state == maestro.WatcherStateReconnecting {
+			reconnects--
+			if reconnects == 0 {
+				cancel()
+			}
+		}
+	}
+	w, err := maestro.NewMongoWatcher(mt.Client, opts)
+	require.NoError(mt, err)
+
+	c := make(chan maestro.QueueUpdateMessage, 16)
+	res.err = w.Watch(ctx, c)
+	if errors.Is(res.err, context.Canceled) {
+		res.err = nil
+	}
+	close(c)
+
+	res.msgs = []maestro.QueueUpdateMessage{}
+	for msg := range c {
+		res.msgs = append(res.msgs, msg)
+	}
+
+	return res
+}
+
+func mockNamespace(mt *mtest.T) string {
+	return mt.Coll.Database().Name() + "." + mt.Coll.Name()
+}
+
+func resumeToken(data string) bson.Raw {
+	raw, err := bson.Marshal(bson.D{{Key: "_data", Value: data}})
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+func TestMongoWatcher_SavesResumeToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Saves Token Of Each Change", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch,
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+					{Key: "operationType", Value: "insert"},
+					{Key: "fullDocument", Value: bson.D{{Key: "name", Value: "one"}}},
+				},
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "2"}}},
+					{Key: "operationType", Value: "drop"},
+				},
+			),
+			mtest.CreateCursorResponse(0, ns, mtest.NextBatch),
+		)
+
+		store := newMemoryTokenStore()
+		res := mockWatch(mt, maestro.MongoWatcherOpts{
+			TokenStore: store,
+			ResumeKey:  "orders",
+		}, 1)
+		require.NoError(mt, res.err)
+		require.Len(mt, res.msgs, 1)
+		require.Equal(mt, maestro.OpTypeInsert, res.msgs[0].OpType)
+		require.Equal(mt, bson.M{"name": "one"}, res.msgs[0].Data)
+
+		// the token moves past changes that aren't sent to the queue too
+		token, err := store.LoadToken(context.Background(), "orders")
+		require.NoError(mt, err)
+		require.Equal(mt, resumeToken("2"), token)
+	})
+
+	mt.Run("Skips Undecodable Change", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch,
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+					{Key: "operationType", Value: 1},
+				},
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "2"}}},
+					{Key: "operationType", Value: "insert"},
+					{Key: "fullDocument", Value: bson.D{{Key: "name", Value: "two"}}},
+				},
+			),
+			mtest.CreateCursorResponse(0, ns, mtest.NextBatch),
+		)
+
+		var skipped []bson.Raw
+		var errs []error
+		store := newMemoryTokenStore()
+		res := mockWatch(mt, maestro.MongoWatcherOpts{
+			TokenStore: store,
+			ResumeKey:  "orders",
+			OnUndecodableChange: func(event bson.Raw, err error) {
+				skipped = append(skipped, event)
+				errs = append(errs, err)
+			},
+		}, 1)
+		require.NoError(mt, res.err)
+		require.Len(mt, res.msgs, 1)
+		require.Equal(mt, bson.M{"name": "two"}, res.msgs[0].Data)
+
+		require.Len(mt, skipped, 1)
+		require.Equal(mt, "1", skipped[0].Lookup("_id", "_data").StringValue())
+		require.ErrorIs(mt, errs[0], maestro.ErrUndecodableChange)
+
+		token, err := store.LoadToken(context.Background(), "orders")
+		require.NoError(mt, err)
+		require.Equal(mt, resumeToken("2"), token)
+	})
+}
+
+func TestMongoWatcher_ResumesFromToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name   string
+		option string
+		opts   maestro.MongoWatcherOpts
+	}{
+		{
+			name:   "Start After",
+			option: "startAfter",
+		},
+		{
+			name:   "Resume After",
+			option: "resumeAfter",
+			opts:   maestro.MongoWatcherOpts{ResumeAfter: true},
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch))
+
+			store := newMemoryTokenStore()
+			require.NoError(mt, store.SaveToken(context.Background(), mockNamespace(mt), resumeToken("1")))
+
+			res := mockWatch(mt, maestro.MongoWatcherOpts{
+				TokenStore:  store,
+				ResumeAfter: tt.opts.ResumeAfter,
+			}, 1)
+			require.NoError(mt, res.err)
+
+			stage := mt.GetStartedEvent().Command.Lookup("pipeline", "0", "$changeStream").Document()
+			require.Equal(mt, resumeToken("1").String(), stage.Lookup(tt.option).Document().String())
+		})
+	}
+}
+
+func TestMongoWatcher_ResyncWhenHistoryLost(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Resync", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{
+				Code:    286,
+				Name:    "ChangeStreamHistoryLost",
+				Message: "resume point no longer in the oplog",
+			}),
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: "a"}, {Key: "name", Value: "one"}},
+				bson.D{{Key: "_id", Value: "b"}, {Key: "name", Value: "two"}},
+			),
+			mtest.CreateCursorResponse(0, ns, mtest.NextBatch),
+		)
+
+		store := newMemoryTokenStore()
+		require.NoError(mt, store.SaveToken(context.Background(), ns, resumeToken("expired")))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{TokenStore: store}, 1)
+		require.NoError(mt, res.err)
+		require.Equal(mt, []maestro.QueueUpdateMessage{
+			{OpType: maestro.OpTypeUpdate, ID: "a", Namespace: mt.Coll.Name(), Data: bson.M{"_id": "a", "name": "one"}},
+			{OpType: maestro.OpTypeUpdate, ID: "b", Namespace: mt.Coll.Name(), Data: bson.M{"_id": "b", "name": "two"}},
+		}, res.msgs)
+
+		// the new stream starts from now rather than the expired token
+		events := mt.GetAllStartedEvents()
+		require.Equal(mt, "aggregate", events[1].CommandName)
+		_, err := events[1].Command.LookupErr("pipeline", "0", "$changeStream", "startAfter")
+		require.Error(mt, err)
+		require.Equal(mt, "find", events[2].CommandName)
+	})
+}
+
+func TestMongoWatcher_Reconnect(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Resumes After Last Change", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		unknownError := mtest.CommandError{Code: 8, Name: "UnknownError", Message: "something went wrong"}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+				{Key: "operationType", Value: "insert"},
+				{Key: "fullDocument", Value: bson.D{{Key: "name", Value: "one"}}},
+			}),
+			mtest.CreateCommandErrorResponse(unknownError),
+			mtest.CreateSuccessResponse(), // killCursors
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: bson.D{{Key: "_data", Value: "2"}}},
+				{Key: "operationType", Value: "insert"},
+				{Key: "fullDocument", Value: bson.D{{Key: "name", Value: "two"}}},
+			}),
+		)
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{}, 2)
+		require.NoError(mt, res.err)
+		require.Len(mt, res.msgs, 2)
+		require.Equal(mt, bson.M{"name": "two"}, res.msgs[1].Data)
+
+		require.Equal(mt, []maestro.WatcherState{
+			maestro.WatcherStateConnecting,
+			maestro.WatcherStateWatching,
+			maestro.WatcherStateReconnecting,
+			maestro.WatcherStateWatching,
+			maestro.WatcherStateReconnecting,
+			maestro.WatcherStateStopped,
+		}, res.states)
+		var se mongo.ServerError
+		require.ErrorAs(mt, res.causes[2], &se)
+		require.True(mt, se.HasErrorCode(8))
+
+		// without a token store the stream resumes from the token kept in memory
+		events := mt.GetAllStartedEvents()
+		require.Equal(mt, "aggregate", events[3].CommandName)
+		stage := events[3].Command.Lookup("pipeline", "0", "$changeStream").Document()
+		require.Equal(mt, resumeToken("1").String(), stage.Lookup("startAfter").Document().String())
+	})
+}
+
+func TestMongoWatcher_StopsOnNonRetryableError(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Unauthorized", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{
+			Code:    13,
+			Name:    "Unauthorized",
+			Message: "not authorized",
+		}))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{}, 1)
+		var se mongo.ServerError
+		require.ErrorAs(mt, res.err, &se)
+		require.True(mt, se.HasErrorCode(13))
+		require.Equal(mt, []maestro.WatcherState{
+			maestro.WatcherStateConnecting,
+			maestro.WatcherStateStopped,
+		}, res.states)
+		require.Equal(mt, res.err, res.causes[1])
+	})
+}
+
+func TestMongoTokenStore(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Load Missing Token", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch))
+
+		token, err := maestro.NewMongoTokenStore(mt.Coll).LoadToken(context.Background(), "orders")
+		require.NoError(mt, err)
+		require.Nil(mt, token)
+	})
+
+	mt.Run("Load Token", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "orders"},
+			{Key: "token", Value: bson.D{{Key: "_data", Value: "1"}}},
+		}))
+
+		token, err := maestro.NewMongoTokenStore(mt.Coll).LoadToken(context.Background(), "orders")
+		require.NoError(mt, err)
+		require.Equal(mt, resumeToken("1"), token)
+	})
+
+	mt.Run("Save Token Upserts", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		err := maestro.NewMongoTokenStore(mt.Coll).SaveToken(context.Background(), "orders", resumeToken("1"))
+		require.NoError(mt, err)
+
+		cmd := mt.GetStartedEvent().Command
+		require.Equal(mt, "orders", cmd.Lookup("updates", "0", "q", "_id").StringValue())
+		require.True(mt, cmd.Lookup("updates", "0", "upsert").Boolean())
+		require.Equal(mt, resumeToken("1").String(), cmd.Lookup("updates", "0", "u", "token").Document().String())
+	})
+}
+
+func mustRawValue(v any) bson.RawValue {
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		panic(err)
+	}
+	return bson.RawValue{Type: t, Value: data}
+}
+
+func mustObjectID(hex string) primitive.ObjectID {
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+func TestMongoDocumentID(t *testing.T) {
+	uuid := primitive.Binary{
+		Subtype: bson.TypeBinaryUUID,
+		Data:    []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0},
+	}
+
+	tests := []struct {
+		id   any
+		name string
+		want string
+	}{
+		{
+			name: "ObjectID",
+			id:   mustObjectID("65f1a2b3c4d5e6f708192a3b"),
+			want: "65f1a2b3c4d5e6f708192a3b",
+		},
+		{
+			name: "String",
+			id:   "order-1",
+			want: "order-1",
+		},
+		{
+			name: "Int32",
+			id:   int32(42),
+			want: "int:42",
+		},
+		{
+			name: "Int64",
+			id:   int64(-9007199254740993),
+			want: "int:-9007199254740993",
+		},
+		{
+			name: "UUID",
+			id:   uuid,
+			want: "uuid:12345678-9abc-def0-1234-56789abcdef0",
+		},
+		{
+			name: "Compound",
+			id:   bson.D{{Key: "tenant", Value: "acme"}, {Key: "seq", Value: int32(7)}},
+			want: `json:{"tenant": "acme","seq": {"$numberInt":"7"}}`,
+		},
+		{
+			name: "String Like An ObjectID",
+			id:   "65f1a2b3c4d5e6f708192a3b",
+			want: "string:65f1a2b3c4d5e6f708192a3b",
+		},
+		{
+			name: "String Like An Int",
+			id:   "int:42",
+			want: "string:int:42",
+		},
+		{
+			name: "Empty String",
+			id:   "",
+			want: "string:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, maestro.MongoDocumentID(mustRawValue(tt.id)))
+		})
+	}
+
+	require.Empty(t, maestro.MongoDocumentID(bson.RawValue{}), "missing _id")
+}
+
+func TestMongoWatcher_DocumentKeys(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		id   any
+		name string
+		want string
+	}{
+		{
+			name: "ObjectID",
+			id:   mustObjectID("65f1a2b3c4d5e6f708192a3b"),
+			want: "65f1a2b3c4d5e6f708192a3b",
+		},
+		{
+			name: "String",
+			id:   "order-1",
+			want: "order-1",
+		},
+		{
+			name: "Int",
+			id:   int32(42),
+			want: "int:42",
+		},
+		{
+			name: "UUID",
+			id:   primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: make([]byte, 16)},
+			want: "uuid:00000000-0000-0000-0000-000000000000",
+		},
+		{
+			name: "Compound",
+			id:   bson.D{{Key: "tenant", Value: "acme"}, {Key: "seq", Value: "7"}},
+			want: `json:{"tenant": "acme","seq": "7"}`,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			ns := mockNamespace(mt)
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+					bson.D{
+						{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+						{Key: "operationType", Value: "update"},
+						{Key: "documentKey", Value: bson.D{{Key: "_id", Value: tt.id}, {Key: "region", Value: "eu"}}},
+						{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: tt.id}}},
+					},
+					bson.D{
+						{Key: "_id", Value: bson.D{{Key: "_data", Value: "2"}}},
+						{Key: "operationType", Value: "delete"},
+						{Key: "documentKey", Value: bson.D{{Key: "_id", Value: tt.id}}},
+					},
+				),
+			)
+
+			res := mockWatch(mt, maestro.MongoWatcherOpts{}, 1)
+			require.NoError(mt, res.err)
+			require.Len(mt, res.msgs, 2)
+			require.Equal(mt, tt.want, res.msgs[0].ID)
+			require.Equal(mt, maestro.OpTypeDelete, res.msgs[1].OpType)
+			require.Equal(mt, tt.want, res.msgs[1].ID)
+		})
+	}
+}
+
+func TestMongoWatcher_FullDocument(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Stream Options", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{
+			FullDocument:             options.UpdateLookup,
+			FullDocumentBeforeChange: options.WhenAvailable,
+		}, 1)
+		require.NoError(mt, res.err)
+
+		stage := mt.GetStartedEvent().Command.Lookup("pipeline", "0", "$changeStream").Document()
+		require.Equal(mt, "updateLookup", stage.Lookup("fullDocument").StringValue())
+		require.Equal(mt, "whenAvailable", stage.Lookup("fullDocumentBeforeChange").StringValue())
+	})
+
+	mt.Run("Default Stream Options", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{}, 1)
+		require.NoError(mt, res.err)
+
+		// updates look up the document so they don't empty the queued item
+		stage := mt.GetStartedEvent().Command.Lookup("pipeline", "0", "$changeStream").Document()
+		require.Equal(mt, "updateLookup", stage.Lookup("fullDocument").StringValue())
+		_, err := stage.LookupErr("fullDocumentBeforeChange")
+		require.Error(mt, err)
+	})
+
+	mt.Run("Update And Replace Events", func(mt *mtest.T) {
+		key := bson.D{{Key: "_id", Value: "a"}}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch,
+			bson.D{
+				{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+				{Key: "operationType", Value: "update"},
+				{Key: "documentKey", Value: key},
+				{Key: "updateDescription", Value: bson.D{
+					{Key: "updatedFields", Value: bson.D{{Key: "status", Value: "ready"}}},
+					{Key: "removedFields", Value: bson.A{"error"}},
+				}},
+				{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: "a"}, {Key: "status", Value: "ready"}}},
+				{Key: "fullDocumentBeforeChange", Value: bson.D{{Key: "_id", Value: "a"}, {Key: "error", Value: "x"}}},
+			},
+			bson.D{
+				{Key: "_id", Value: bson.D{{Key: "_data", Value: "2"}}},
+				{Key: "operationType", Value: "replace"},
+				{Key: "documentKey", Value: key},
+				{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: "a"}, {Key: "status", Value: "done"}}},
+			},
+		))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{}, 1)
+		require.NoError(mt, res.err)
+		require.Equal(mt, []maestro.QueueUpdateMessage{
+			{
+				Data:   bson.M{"_id": "a", "status": "ready"},
+				Before: bson.M{"_id": "a", "error": "x"},
+				UpdateDescription: &maestro.UpdateDescription{
+					UpdatedFields: bson.M{"status": "ready"},
+					RemovedFields: []string{"error"},
+				},
+				ID:     "a",
+				OpType: maestro.OpTypeUpdate,
+			},
+			{
+				Data:   bson.M{"_id": "a", "status": "done"},
+				ID:     "a",
+				OpType: maestro.OpTypeUpdate,
+			},
+		}, res.msgs)
+	})
+}
+
+func TestMongoWatcher_Filter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	var fromConfig bson.M
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"status": "ready",
+		"$or": [{"priority": {"$gte": 2}}, {"tags": "urgent"}]
+	}`), &fromConfig))
+
+	tests := []struct {
+		name     string
+		opts     maestro.MongoWatcherOpts
+		pipeline string
+	}{
+		{
+			name:     "No Filter",
+			opts:     maestro.MongoWatcherOpts{},
+			pipeline: `[{"$changeStream": {"fullDocument": "updateLookup"}}]`,
+		},
+		{
+			name: "Filter",
+			opts: maestro.MongoWatcherOpts{
+				Filter: fromConfig,
+			},
+			pipeline: `[{"$changeStream": {"fullDocument": "updateLookup"}},` +
+				`{"$match": {"$or": [{"operationType": "delete"},{` +
+				`"$or": [{"fullDocument.priority": {"$gte": {"$numberDouble":"2.0"}}},{"fullDocument.tags": "urgent"}],` +
+				`"fullDocument.status": "ready"}]}}]`,
+		},
+		{
+			name: "Pipeline After Filter",
+			opts: maestro.MongoWatcherOpts{
+				Filter: bson.M{"status": "ready"},
+				Pipeline: mongo.Pipeline{
+					{{Key: "$project", Value: bson.D{{Key: "fullDocument.secret", Value: 0}}}},
+				},
+				FullDocument: options.WhenAvailable,
+			},
+			pipeline: `[{"$changeStream": {"fullDocument": "whenAvailable"}},` +
+				`{"$match": {"$or": [{"operationType": "delete"},{"fullDocument.status": "ready"}]}},` +
+				`{"$project": {"fullDocument.secret": {"$numberInt":"0"}}}]`,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, mockNamespace(mt), mtest.FirstBatch))
+
+			res := mockWatch(mt, tt.opts, 1)
+			require.NoError(mt, res.err)
+			require.Equal(mt, tt.pipeline, mt.GetStartedEvent().Command.Lookup("pipeline").String())
+		})
+	}
+
+	mt.Run("Resync Scans Matching Documents", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{
+				Code:    286,
+				Name:    "ChangeStreamHistoryLost",
+				Message: "resume point no longer in the oplog",
+			}),
+			mtest.CreateCursorResponse(1, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.NextBatch),
+		)
+
+		store := newMemoryTokenStore()
+		require.NoError(mt, store.SaveToken(context.Background(), ns, resumeToken("expired")))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{
+			TokenStore: store,
+			Filter:     bson.M{"status": "ready"},
+		}, 1)
+		require.NoError(mt, res.err)
+
+		find := mt.GetAllStartedEvents()[2]
+		require.Equal(mt, "find", find.CommandName)
+		require.Equal(mt, `{"status": "ready"}`, find.Command.Lookup("filter").Document().String())
+	})
+}
+
+func TestMongoWatcher_Collections(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name     string
+		pipeline string
+		key      string
+		opts     maestro.MongoWatcherOpts
+	}{
+		{
+			name:     "Several Collections",
+			opts:     maestro.MongoWatcherOpts{CollectionNames: []string{"orders", "invoices"}},
+			pipeline: `[{"$changeStream": {"fullDocument": "updateLookup"}},{"$match": {"ns.coll": {"$in": ["invoices","orders"]}}}]`,
+			key:      "test.invoices,orders",
+		},
+		{
+			name:     "Whole Database",
+			opts:     maestro.MongoWatcherOpts{},
+			pipeline: `[{"$changeStream": {"fullDocument": "updateLookup"}}]`,
+			key:      "test",
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			ns := mt.Coll.Database().Name() + ".$cmd.aggregate"
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+					{Key: "operationType", Value: "insert"},
+					{Key: "ns", Value: bson.D{{Key: "db", Value: "test"}, {Key: "coll", Value: "orders"}}},
+					{Key: "documentKey", Value: bson.D{{Key: "_id", Value: "a"}}},
+				},
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "2"}}},
+					{Key: "operationType", Value: "insert"},
+					{Key: "ns", Value: bson.D{{Key: "db", Value: "test"}, {Key: "coll", Value: "invoices"}}},
+					{Key: "documentKey", Value: bson.D{{Key: "_id", Value: "a"}}},
+				},
+			))
+
+			store := newMemoryTokenStore()
+			opts := tt.opts
+			opts.TokenStore = store
+			opts.DatabaseName = mt.Coll.Database().Name()
+
+			res := mockWatch(mt, opts, 1)
+			require.NoError(mt, res.err)
+			require.Len(mt, res.msgs, 2)
+			require.Equal(mt, "orders", res.msgs[0].Namespace)
+			require.Equal(mt, "invoices", res.msgs[1].Namespace)
+			require.Equal(mt, res.msgs[0].ID, res.msgs[1].ID)
+
+			cmd := mt.GetStartedEvent().Command
+			require.Equal(mt, int32(1), cmd.Lookup("aggregate").Int32(), "stream should be opened on the database")
+			require.Equal(mt, tt.pipeline, cmd.Lookup("pipeline").String())
+
+			token, err := store.LoadToken(context.Background(), tt.key)
+			require.NoError(mt, err)
+			require.Equal(mt, resumeToken("2"), token)
+		})
+	}
+}
+
+func TestMongoWatcher_Bootstrap(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	startAt := primitive.Timestamp{T: 1700000000, I: 3}
+
+	mt.Run("Scans Then Watches From Before The Scan", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "operationTime", Value: startAt}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: "a"}, {Key: "status", Value: "ready"}},
+				bson.D{{Key: "_id", Value: "b"}, {Key: "status", Value: "ready"}},
+			),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{
+					{Key: "_id", Value: bson.D{{Key: "_data", Value: "1"}}},
+					{Key: "operationType", Value: "update"},
+					{Key: "documentKey", Value: bson.D{{Key: "_id", Value: "a"}}},
+					{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: "a"}, {Key: "status", Value: "ready"}}},
+				},
+			),
+		)
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{
+			Bootstrap: true,
+			Filter:    bson.M{"status": "ready"},
+		}, 1)
+		require.NoError(mt, res.err)
+		require.Len(mt, res.msgs, 3)
+		for i, id := range []string{"a", "b"} {
+			require.Equal(mt, maestro.OpTypeInsert, res.msgs[i].OpType)
+			require.Equal(mt, id, res.msgs[i].ID)
+			require.Equal(mt, mt.Coll.Name(), res.msgs[i].Namespace)
+		}
+		require.Equal(mt, maestro.OpTypeUpdate, res.msgs[2].OpType)
+
+		events := mt.GetAllStartedEvents()
+		require.Equal(mt, "ping", events[0].CommandName)
+
+		find := events[1]
+		require.Equal(mt, "find", find.CommandName)
+		require.Equal(mt, `{"status": "ready"}`, find.Command.Lookup("filter").Document().String())
+		require.Equal(mt, `{"_id": {"$numberInt":"1"}}`, find.Command.Lookup("sort").Document().String())
+
+		stage := events[2].Command.Lookup("pipeline", "0", "$changeStream").Document()
+		t, i := stage.Lookup("startAtOperationTime").Timestamp()
+		require.Equal(mt, startAt, primitive.Timestamp{T: t, I: i})
+	})
+
+	mt.Run("Reconnects From Before The Scan", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "operationTime", Value: startAt}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: "a"}}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 8, Name: "UnknownError", Message: "lost"}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+		)
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{Bootstrap: true}, 2)
+		require.NoError(mt, res.err)
+		require.Len(mt, res.msgs, 1, "the collection should only be scanned once")
+
+		events := mt.GetAllStartedEvents()
+		require.Len(mt, events, 4)
+		for _, e := range events[2:] {
+			require.Equal(mt, "aggregate", e.CommandName)
+			_, err := e.Command.LookupErr("pipeline", "0", "$changeStream", "startAtOperationTime")
+			require.NoError(mt, err)
+		}
+	})
+
+	mt.Run("Skipped When Resuming", func(mt *mtest.T) {
+		ns := mockNamespace(mt)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+
+		store := newMemoryTokenStore()
+		require.NoError(mt, store.SaveToken(context.Background(), ns, resumeToken("1")))
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{
+			TokenStore: store,
+			Bootstrap:  true,
+		}, 1)
+		require.NoError(mt, res.err)
+		require.Empty(mt, res.msgs)
+		require.Equal(mt, "aggregate", mt.GetStartedEvent().CommandName)
+	})
+
+	mt.Run("Needs An Operation Time", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		res := mockWatch(mt, maestro.MongoWatcherOpts{Bootstrap: true}, 1)
+		require.ErrorIs(mt, res.err, maestro.ErrNoOperationTime)
+	})
+}
+
+func TestMongoWriter(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	newWriter := func(mt *mtest.T, opts maestro.MongoWriterOpts) *maestro.MongoWriter {
+		mt.Helper()
+
+		opts.DatabaseName = mt.Coll.Database().Name()
+		opts.CollectionName = mt.Coll.Name()
+		w, err := maestro.NewMongoWriter(mt.Client, opts)
+		require.NoError(mt, err)
+		return w
+	}
+
+	mt.Run("Write", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		id := "65a1f0c2e4b0a1b2c3d4e5f6"
+		w := newWriter(mt, maestro.MongoWriterOpts{})
+		require.NoError(mt, w.Write(maestro.NewQueueItem(id, []byte(`{"_id": "ignored", "status": "ready"}`))))
+
+		cmd := mt.GetStartedEvent().Command
+		require.Equal(mt, mt.Coll.Name(), cmd.Lookup("insert").StringValue())
+		require.False(mt, cmd.Lookup("ordered").Boolean())
+		require.Equal(mt, `{"w": "majority"}`, cmd.Lookup("writeConcern").Document().String())
+
+		doc := cmd.Lookup("documents", "0").Document()
+		require.Equal(mt, mustObjectID(id), doc.Lookup("_id").ObjectID())
+		require.Equal(mt, "ready", doc.Lookup("status").StringValue())
+	})
+
+	mt.Run("IDs Decode To Their _id", func(mt *mtest.T) {
+		ids := []any{
+			"order-1",
+			"65a1f0c2e4b0a1b2c3d4e5f6",
+			int32(42),
+			int64(1) << 40,
+			primitive.Binary{Subtype: bson.TypeBinaryUUID, Data: make([]byte, 16)},
+			bson.D{{Key: "tenant", Value: "acme"}, {Key: "seq", Value: int32(7)}},
+		}
+		for _, id := range ids {
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+			raw := mustRawValue(id)
+			w := newWriter(mt, maestro.MongoWriterOpts{})
+			require.NoError(mt, w.Write(maestro.NewQueueItem(maestro.MongoDocumentID(raw), nil)))
+
+			got := mt.GetStartedEvent().Command.Lookup("documents", "0", "_id")
+			require.True(mt, raw.Equal(got), "%s: wrote %s", raw, got)
+		}
+	})
+
+	mt.Run("Documents And Other Data", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		w := newWriter(mt, maestro.MongoWriterOpts{WriteConcern: writeconcern.W1()})
+		require.NoError(mt, w.WriteMany([]maestro.QueueItem{
+			maestro.NewQueueItem("a", bson.M{"status": "ready"}),
+			maestro.NewQueueItem("b", []byte("not json")),
+			maestro.NewQueueItem("c", nil),
+		}))
+
+		cmd := mt.GetStartedEvent().Command
+		require.Equal(mt, `{"w": {"$numberInt":"1"}}`, cmd.Lookup("writeConcern").Document().String())
+		require.Equal(mt, `[{"_id": "a","status": "ready"},`+
+			`{"_id": "b","data": {"$binary":{"base64":"bm90IGpzb24=","subType":"00"}}},`+
+			`{"_id": "c"}]`, cmd.Lookup("documents").String())
+	})
+
+	mt.Run("Duplicate Keys Succeed", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicateKeyResponse("_id", "_id_"))
+
+		w := newWriter(mt, maestro.MongoWriterOpts{})
+		require.NoError(mt, w.Write(maestro.NewQueueItem("a", nil)))
+	})
+
+	mt.Run("Duplicate Keys Without Key Pattern Succeed", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: `E11000 duplicate key error collection: db.orders index: _id_ dup key: { _id: "a" }`,
+		}))
+
+		w := newWriter(mt, maestro.MongoWriterOpts{})
+		require.NoError(mt, w.Write(maestro.NewQueueItem("a", nil)))
+	})
+
+	mt.Run("Other Unique Index Fails", func(mt *mtest.T) {
+		mt.AddMockResponses(duplicateKeyResponse("email", "email_1"))
+
+		w := newWriter(mt, maestro.MongoWriterOpts{})
+		require.ErrorContains(mt, w.Write(maestro.NewQueueItem("a", nil)), "email_1")
+	})
+
+	mt.Run("Other Write Errors Fail", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(
+			mtest.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error collection: db.orders index: _id_ dup key: { _id: \"a\" }"},
+			mtest.WriteError{Index: 1, Code: 121, Message: "document failed validation"},
+		))
+
+		w := newWriter(mt, maestro.MongoWriterOpts{})
+		err := w.WriteMany([]maestro.QueueItem{maestro.NewQueueItem("a", nil), maestro.NewQueueItem("b", nil)})
+		require.ErrorContains(mt, err, "document failed validation")
+	})
+}
+
+// duplicateKeyResponse is an insert that failed on a value already in the
+// unique index on key.
+func duplicateKeyResponse(key string, index string) bson.D {
+	return bson.D{
+		{Key: "ok", Value: 1},
+		{Key: "n", Value: 0},
+		{Key: "writeErrors", Value: bson.A{bson.D{
+			{Key: "index", Value: 0},
+			{Key: "code", Value: 11000},
+			{Key: "errmsg", Value: "E11000 duplicate key error collection: db.orders index: " + index + " dup key: { " + key + ": \"a\" }"},
+			{Key: "keyPattern", Value: bson.D{{Key: key, Value: 1}}},
+			{Key: "keyValue", Value: bson.D{{Key: key, Value: "a"}}},
+		}}},
+	}
+}
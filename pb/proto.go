@@ -1,26 +1,46 @@
 package pb
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/charlieplate/maestro"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 const (
+	// Deprecated: messages are parsed by their type in the parser's Registry.
 	MsgTypeSubscribe = "Subscribe"
+
+	// ProtoVersion is sent in the envelope of every encoded message.
+	ProtoVersion = "3.0.0"
 )
 
-type ProtobufParser struct{}
+// ProtobufParser parses and encodes protobuf messages. The zero value parses
+// the messages of this package.
+type ProtobufParser struct {
+	// Registry holds the messages the parser understands. Register an
+	// application's own messages here. A shared registry of this package's
+	// messages is used when nil.
+	Registry *Registry
+}
 
-var registry = map[string]string{}
+var (
+	_ maestro.Parser  = (*ProtobufParser)(nil)
+	_ maestro.Encoder = (*ProtobufParser)(nil)
+)
 
+// NewProtobufParser returns a parser with its own registry, so messages
+// registered on it don't affect other parsers.
 func NewProtobufParser() *ProtobufParser {
-	return &ProtobufParser{}
+	return &ProtobufParser{
+		Registry: NewRegistry(),
+	}
 }
 
 // Returns the message, the type of the message, and an error
@@ -43,35 +63,86 @@ func (pbd *ProtobufParser) Parse(data any) (maestro.Message, error) {
 	if err = validProtoVersion(msg.GetProtoVersion()); err != nil {
 		return m, err
 	}
-	c := msg.GetContent()
-
-	var msgType string
-	if mt, ok := registry[c.GetTypeUrl()]; !ok {
-		t, readErr := protoregistry.GlobalTypes.FindMessageByURL(c.GetTypeUrl())
-		if readErr != nil {
-			return m, readErr
-		}
 
-		msgType = string(t.Descriptor().Name())
-		registry[c.GetTypeUrl()] = msgType
-	} else {
-		msgType = mt
+	r := pbd.Registry
+	if r == nil {
+		r = defaultRegistry()
 	}
+	m.ActionType, m.Content, err = r.Decode(msg.GetContent())
+	if err != nil {
+		return maestro.Message{}, err
+	}
+
+	return m, nil
+}
+
+var ErrUnsupportedContent = errors.New("unsupported content")
 
-	switch msgType {
-	case MsgTypeSubscribe:
-		m.ActionType = maestro.ActionTypeSubscribe
-		sub := &Subscribe{}
-		err = c.UnmarshalTo(sub)
+// Encode wraps an outgoing message in a Message envelope. Deliveries and the
+// broker's replies are converted to their protobuf messages, and content that
+// is already a proto.Message is sent as is.
+func (pbd *ProtobufParser) Encode(msg maestro.Message) ([]byte, error) {
+	var content proto.Message
+	switch c := msg.Content.(type) {
+	case *maestro.Delivery:
+		data, err := encodeData(c.Item.Data())
 		if err != nil {
-			return m, err
+			return nil, err
 		}
-		m.Content = sub
+		content = &Delivery{
+			Queue:       c.Queue,
+			DeliveryTag: c.Tag,
+			ID:          c.Item.ID(),
+			Data:        data,
+			Attempt:     uint32(c.Attempt), //nolint:gosec // attempts are small and never negative
+		}
+	case *maestro.Published:
+		content = &PublishOk{
+			Queue:     c.Queue,
+			ID:        c.ID,
+			RequestID: c.RequestID,
+		}
+	case *maestro.Subscribed:
+		content = &SubscribeOk{
+			Queue:     c.Queue,
+			RequestID: c.RequestID,
+		}
+	case *maestro.ErrorReply:
+		content = &Error{
+			Code:      ErrorCode(c.Code),
+			Message:   c.Message,
+			RequestID: c.RequestID,
+		}
+	case proto.Message:
+		content = c
 	default:
-		return m, errors.New("unknown message type")
+		return nil, fmt.Errorf("encode: %w: %T", ErrUnsupportedContent, msg.Content)
 	}
 
-	return m, nil
+	a, err := anypb.New(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return proto.Marshal(&Message{
+		ProtoVersion: ProtoVersion,
+		Content:      a,
+	})
+}
+
+// encodeData converts an item's data to bytes. Byte slices and strings are
+// sent unchanged and anything else, such as a Mongo document, as JSON.
+func encodeData(data any) ([]byte, error) {
+	switch d := data.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return json.Marshal(d)
+	}
 }
 
 func unmarshalMessage(d []byte) (*Message, error) {
@@ -1,15 +1,18 @@
 package pb_test
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/charlieplate/maestro"
 	"github.com/charlieplate/maestro/pb"
 	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type testMsg struct {
@@ -38,21 +41,70 @@ func mustUnmarshalMessage(tm testMsg, content proto.Message) []byte {
 }
 
 func TestNewProtobufParser(t *testing.T) {
-	tests := []struct {
-		want *pb.ProtobufParser
-		name string
-	}{
-		{
-			name: "Test NewProtobufParser",
-			want: &pb.ProtobufParser{},
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			require.Equal(t, tt.want, pb.NewProtobufParser())
-			require.Implements(t, (*maestro.Parser)(nil), tt.want)
-		})
+	p := pb.NewProtobufParser()
+	require.Implements(t, (*maestro.Parser)(nil), p)
+	require.NotNil(t, p.Registry)
+	require.NotSame(t, p.Registry, pb.NewProtobufParser().Registry, "each parser should have its own registry")
+}
+
+func TestRegistry_Register(t *testing.T) {
+	const actionPing maestro.ActionType = "ping"
+	ping := mustUnmarshalMessage(testMsg{Version: "3.0.0"}, wrapperspb.String("hello"))
+
+	p := pb.NewProtobufParser()
+	_, err := p.Parse(ping)
+	require.ErrorIs(t, err, pb.ErrUnknownMessageType)
+
+	p.Registry.Register(&wrapperspb.StringValue{}, actionPing, nil)
+	msg, err := p.Parse(ping)
+	require.NoError(t, err)
+	require.Equal(t, actionPing, msg.ActionType)
+	require.True(t, proto.Equal(wrapperspb.String("hello"), msg.Content.(proto.Message)))
+
+	// a decode func can turn the message into anything
+	p.Registry.Register(&wrapperspb.StringValue{}, actionPing, func(content *anypb.Any) (any, error) {
+		s := &wrapperspb.StringValue{}
+		if err := content.UnmarshalTo(s); err != nil {
+			return nil, err
+		}
+		return s.GetValue(), nil
+	})
+	msg, err = p.Parse(ping)
+	require.NoError(t, err)
+	require.Equal(t, "hello", msg.Content)
+
+	// other parsers are unaffected
+	_, err = pb.NewProtobufParser().Parse(ping)
+	require.ErrorIs(t, err, pb.ErrUnknownMessageType)
+	_, err = (&pb.ProtobufParser{}).Parse(ping)
+	require.ErrorIs(t, err, pb.ErrUnknownMessageType)
+
+	p.Registry.Unregister(&wrapperspb.StringValue{})
+	_, err = p.Parse(ping)
+	require.ErrorIs(t, err, pb.ErrUnknownMessageType)
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	p := pb.NewProtobufParser()
+	sub := mustUnmarshalMessage(testMsg{Version: "3.0.0"}, &pb.Subscribe{Queue: "test123"})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if i%2 == 0 {
+					p.Registry.Register(&wrapperspb.StringValue{}, "ping", nil)
+					continue
+				}
+				msg, err := p.Parse(sub)
+				assert.NoError(t, err)
+				assert.Equal(t, maestro.ActionTypeSubscribe, msg.ActionType)
+			}
+		}()
 	}
+	wg.Wait()
 }
 
 func TestProtobuf_ParseIncoming(t *testing.T) {
@@ -61,6 +113,7 @@ func TestProtobuf_ParseIncoming(t *testing.T) {
 		ExpectedContentType any
 		ExpectedError       error
 		Name                string
+		ExpectedActionType  maestro.ActionType
 		Incoming            []byte
 	}{
 		{
@@ -73,7 +126,111 @@ func TestProtobuf_ParseIncoming(t *testing.T) {
 			ExpectedContent: &pb.Subscribe{
 				Queue: "test123",
 			},
-			ExpectedError: nil,
+			ExpectedActionType: maestro.ActionTypeSubscribe,
+			ExpectedError:      nil,
+		},
+		{
+			Name: "Unsubscribe",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Unsubscribe{
+				Queue: "test123",
+			}),
+			ExpectedContent: &pb.Unsubscribe{
+				Queue: "test123",
+			},
+			ExpectedActionType: maestro.ActionTypeUnsubscribe,
+			ExpectedError:      nil,
+		},
+		{
+			Name: "Ack",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Ack{
+				Queue:       "test123",
+				DeliveryTag: "1",
+			}),
+			ExpectedContent: &pb.Ack{
+				Queue:       "test123",
+				DeliveryTag: "1",
+			},
+			ExpectedActionType: maestro.ActionTypeAcknowledge,
+			ExpectedError:      nil,
+		},
+		{
+			Name: "Nack",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Nack{
+				Queue:       "test123",
+				DeliveryTag: "1",
+				Reason:      "bad payload",
+				Requeue:     true,
+			}),
+			ExpectedContent: &pb.Nack{
+				Queue:       "test123",
+				DeliveryTag: "1",
+				Reason:      "bad payload",
+				Requeue:     true,
+			},
+			ExpectedActionType: maestro.ActionTypeNack,
+			ExpectedError:      nil,
+		},
+		{
+			Name: "Publish",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Publish{
+				Queue: "test123",
+				ID:    "a",
+				Data:  []byte("one"),
+			}),
+			ExpectedContent: &pb.Publish{
+				Queue: "test123",
+				ID:    "a",
+				Data:  []byte("one"),
+			},
+			ExpectedActionType: maestro.ActionTypePublish,
+			ExpectedError:      nil,
+		},
+		{
+			Name: "Delivery",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Delivery{Queue: "test123", DeliveryTag: "1", ID: "a"}),
+			ExpectedContent:    &pb.Delivery{Queue: "test123", DeliveryTag: "1", ID: "a"},
+			ExpectedActionType: maestro.ActionTypeDeliver,
+		},
+		{
+			Name: "SubscribeOk",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.SubscribeOk{Queue: "test123", RequestID: "r1"}),
+			ExpectedContent:    &pb.SubscribeOk{Queue: "test123", RequestID: "r1"},
+			ExpectedActionType: maestro.ActionTypeSubscribed,
+		},
+		{
+			Name: "PublishOk",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.PublishOk{Queue: "test123", ID: "a", RequestID: "r1"}),
+			ExpectedContent:    &pb.PublishOk{Queue: "test123", ID: "a", RequestID: "r1"},
+			ExpectedActionType: maestro.ActionTypePublished,
+		},
+		{
+			Name: "Error",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Error{Code: pb.ErrorCode_ERROR_CODE_QUEUE_NOT_FOUND, Message: "queue not found", RequestID: "r1"}),
+			ExpectedContent:    &pb.Error{Code: pb.ErrorCode_ERROR_CODE_QUEUE_NOT_FOUND, Message: "queue not found", RequestID: "r1"},
+			ExpectedActionType: maestro.ActionTypeError,
+		},
+		{
+			Name: "Unknown Message Type",
+			Incoming: mustUnmarshalMessage(testMsg{
+				Version: "3.0.0",
+			}, &pb.Message{}),
+			ExpectedError: pb.ErrUnknownMessageType,
 		},
 		{
 			Name: "Invalid Version",
@@ -90,12 +247,105 @@ func TestProtobuf_ParseIncoming(t *testing.T) {
 			pbc := pb.ProtobufParser{}
 			msg, err := pbc.Parse(tc.Incoming)
 			if tc.ExpectedError != nil {
-				require.Error(t, err, "Error expected")
+				require.ErrorIs(t, err, tc.ExpectedError)
 			} else {
 				require.NoError(t, err, "Error not expected")
 				require.Zero(t, msg.ConnID, "ConnID should be nil")
+				require.Equal(t, tc.ExpectedActionType, msg.ActionType)
 				require.True(t, cmp.Equal(tc.ExpectedContent, msg.Content, protocmp.Transform()), cmp.Diff(tc.ExpectedContent, msg.Content, protocmp.Transform()))
 			}
 		})
 	}
 }
+
+func TestProtobufParser_Encode(t *testing.T) {
+	testCases := []struct {
+		ExpectedContent proto.Message
+		ExpectedError   error
+		Content         any
+		Name            string
+	}{
+		{
+			Name: "Delivery",
+			Content: &maestro.Delivery{
+				Item:    maestro.NewQueueItem("a", "one"),
+				Tag:     "1",
+				Queue:   "test123",
+				Attempt: 2,
+			},
+			ExpectedContent: &pb.Delivery{
+				Queue:       "test123",
+				DeliveryTag: "1",
+				ID:          "a",
+				Data:        []byte("one"),
+				Attempt:     2,
+			},
+		},
+		{
+			Name: "Delivery With JSON Data",
+			Content: &maestro.Delivery{
+				Item:  maestro.NewQueueItem("a", map[string]int{"count": 1}),
+				Tag:   "1",
+				Queue: "test123",
+			},
+			ExpectedContent: &pb.Delivery{
+				Queue:       "test123",
+				DeliveryTag: "1",
+				ID:          "a",
+				Data:        []byte(`{"count":1}`),
+			},
+		},
+		{
+			Name:            "Published",
+			Content:         &maestro.Published{Queue: "test123", ID: "a"},
+			ExpectedContent: &pb.PublishOk{Queue: "test123", ID: "a"},
+		},
+		{
+			Name:            "Subscribed",
+			Content:         &maestro.Subscribed{Queue: "test123", RequestID: "r1"},
+			ExpectedContent: &pb.SubscribeOk{Queue: "test123", RequestID: "r1"},
+		},
+		{
+			Name: "Error Reply",
+			Content: &maestro.ErrorReply{
+				RequestID: "r1",
+				Message:   "queue not found",
+				Code:      maestro.ErrorCodeQueueNotFound,
+			},
+			ExpectedContent: &pb.Error{
+				Code:      pb.ErrorCode_ERROR_CODE_QUEUE_NOT_FOUND,
+				Message:   "queue not found",
+				RequestID: "r1",
+			},
+		},
+		{
+			Name:            "Proto Message",
+			Content:         &pb.Subscribe{Queue: "test123"},
+			ExpectedContent: &pb.Subscribe{Queue: "test123"},
+		},
+		{
+			Name:          "Unsupported Content",
+			Content:       "test123",
+			ExpectedError: pb.ErrUnsupportedContent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			pbc := pb.ProtobufParser{}
+			data, err := pbc.Encode(maestro.Message{Content: tc.Content})
+			require.ErrorIs(t, err, tc.ExpectedError)
+			if tc.ExpectedError != nil {
+				return
+			}
+
+			msg := &pb.Message{}
+			require.NoError(t, proto.Unmarshal(data, msg))
+			require.Equal(t, pb.ProtoVersion, msg.GetProtoVersion())
+
+			content, err := msg.GetContent().UnmarshalNew()
+			require.NoError(t, err)
+			require.True(t, cmp.Equal(tc.ExpectedContent, content, protocmp.Transform()), cmp.Diff(tc.ExpectedContent, content, protocmp.Transform()))
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package pb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/charlieplate/maestro"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DecodeFunc turns the content of a Message into the content of a
+// maestro.Message.
+type DecodeFunc func(content *anypb.Any) (any, error)
+
+// Registry maps the protobuf messages a ProtobufParser understands to the
+// action each triggers. It is safe for concurrent use, so messages can be
+// registered while the parser is in use.
+type Registry struct {
+	types map[protoreflect.FullName]registration
+	mutex sync.RWMutex
+}
+
+type registration struct {
+	decode DecodeFunc
+	action maestro.ActionType
+}
+
+var ErrUnknownMessageType = errors.New("unknown message type")
+
+// NewRegistry returns a registry holding the messages of this package.
+// Messages sent by the server are included so clients can parse them too.
+func NewRegistry() *Registry {
+	r := &Registry{
+		types: make(map[protoreflect.FullName]registration),
+		mutex: sync.RWMutex{},
+	}
+
+	r.Register(&Subscribe{}, maestro.ActionTypeSubscribe, nil)
+	r.Register(&Unsubscribe{}, maestro.ActionTypeUnsubscribe, nil)
+	r.Register(&Ack{}, maestro.ActionTypeAcknowledge, nil)
+	r.Register(&Nack{}, maestro.ActionTypeNack, nil)
+	r.Register(&Publish{}, maestro.ActionTypePublish, nil)
+	r.Register(&Delivery{}, maestro.ActionTypeDeliver, nil)
+	r.Register(&SubscribeOk{}, maestro.ActionTypeSubscribed, nil)
+	r.Register(&PublishOk{}, maestro.ActionTypePublished, nil)
+	r.Register(&Error{}, maestro.ActionTypeError, nil)
+
+	return r
+}
+
+// defaultRegistry is used by parsers without a registry of their own. It is
+// built on first use, as the message types aren't ready until the package's
+// init functions have run.
+var defaultRegistry = sync.OnceValue(NewRegistry)
+
+// Register makes messages of msg's type parse to action, replacing any
+// previous registration of the type. decode defaults to unmarshalling into a
+// new message of msg's type.
+func (r *Registry) Register(msg proto.Message, action maestro.ActionType, decode DecodeFunc) {
+	mt := msg.ProtoReflect().Type()
+	if decode == nil {
+		decode = func(content *anypb.Any) (any, error) {
+			m := mt.New().Interface()
+			if err := content.UnmarshalTo(m); err != nil {
+				return nil, err
+			}
+			return m, nil
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.types[mt.Descriptor().FullName()] = registration{
+		decode: decode,
+		action: action,
+	}
+}
+
+// Unregister stops messages of msg's type from being parsed.
+func (r *Registry) Unregister(msg proto.Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.types, msg.ProtoReflect().Descriptor().FullName())
+}
+
+// Decode returns the action and decoded content of a Message's content.
+func (r *Registry) Decode(content *anypb.Any) (maestro.ActionType, any, error) {
+	name := content.MessageName()
+
+	r.mutex.RLock()
+	reg, ok := r.types[name]
+	r.mutex.RUnlock()
+
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnknownMessageType, name)
+	}
+
+	decoded, err := reg.decode(content)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return reg.action, decoded, nil
+}
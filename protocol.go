@@ -1,7 +1,7 @@
 package maestro
 
 import (
-	"encoding/binary"
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -12,13 +12,23 @@ type Protocol interface {
 	Authenticator
 	Parser
 	ParseIncoming(data any) (Message, error)
+	// EncodeOutgoing encodes a message for a peer, ready to write to the wire.
+	EncodeOutgoing(msg Message) ([]byte, error)
 }
 
+// ProtocolVersion is the frame version written by BinaryAuthContentProtocol.
+const ProtocolVersion = 1
+
 type BinaryAuthContentProtocol struct {
 	Authenticator Authenticator
 	Parser        Parser
+	// Encoder encodes the content of outgoing frames. When nil the Parser is
+	// used if it also implements Encoder.
+	Encoder Encoder
 }
 
+var _ Protocol = (*BinaryAuthContentProtocol)(nil)
+
 type BinaryAuthContentMessage struct {
 	Auth        []byte
 	Content     []byte
@@ -32,109 +42,53 @@ type AuthInfo struct {
 	ConnID string
 }
 
-func (au *BinaryAuthContentProtocol) Authenticate(m AuthInfo) (AuthInfo, error) {
-	return au.Authenticator.Authenticate(m)
+func (au *BinaryAuthContentProtocol) Authenticate(auth any) (AuthInfo, error) {
+	return au.Authenticator.Authenticate(auth)
 }
 
 func (au *BinaryAuthContentProtocol) Parse(data any) (Message, error) {
 	return au.Parser.Parse(data)
 }
 
-func checkSeparator(d []byte, offset int) (int, error) {
-	if d[offset] != 0x1E {
-		return 0, fmt.Errorf("checkNextByteIsSeperator: %w", errors.New("invalid seperator"))
-	}
-	return offset + 1, nil
-}
-
 var ErrInvalidTerminator = errors.New("invalid terminator")
 
+// parseToMessage decodes a buffer holding exactly one frame.
 func (au *BinaryAuthContentProtocol) parseToMessage(d []byte) (BinaryAuthContentMessage, error) {
-	offset := 0
-	acm := BinaryAuthContentMessage{}
-	var err error
-
-	ver, err := safeByteRange(d, offset, 4)
-	if err != nil {
-		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: failed to read version: %w", err)
-	}
-	acm.Version = int(binary.BigEndian.Uint32(ver))
-	offset += 4
-
-	if offset, err = checkSeparator(d, offset); err != nil {
-		return BinaryAuthContentMessage{}, err
-	}
-
-	as, err := safeByteRange(d, offset, offset+4)
-	if err != nil {
-		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: failed to read auth size: %w", err)
-	}
-	acm.AuthSize = int(binary.BigEndian.Uint32(as))
-	offset += 4
-
-	if offset, err = checkSeparator(d, offset); err != nil {
-		return BinaryAuthContentMessage{}, err
-	}
-
-	auth, err := safeByteRange(d, offset, offset+acm.AuthSize)
-	if err != nil {
-		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: failed to read auth: %w", err)
-	}
-	acm.Auth = auth
-	offset += acm.AuthSize
-
-	if offset, err = checkSeparator(d, offset); err != nil {
-		return BinaryAuthContentMessage{}, err
-	}
-
-	cs, err := safeByteRange(d, offset, offset+4)
-	if err != nil {
-		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: failed to read content size: %w", err)
-	}
-	acm.ContentSize = int(binary.BigEndian.Uint32(cs))
-	offset += 4
-
-	if offset, err = checkSeparator(d, offset); err != nil {
-		return BinaryAuthContentMessage{}, err
-	}
-
-	content, err := safeByteRange(d, offset, offset+acm.ContentSize)
-	if err != nil {
-		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: failed to read content: %w", err)
-	}
-	acm.Content = content
-	offset += acm.ContentSize
+	r := bytes.NewReader(d)
+	fr := NewFrameReader(r, FrameReaderOpts{
+		MaxAuthSize:    len(d),
+		MaxContentSize: len(d),
+		Resync:         false,
+	})
 
-	term, err := safeByteRange(d, offset, offset+3)
+	acm, err := fr.ReadFrame()
 	if err != nil {
-		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: could not read terminator: %w", ErrInvalidTerminator)
+		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: %w", err)
 	}
-	offset += 3
 
-	if _, isNotEOF := safeByteRange(d, offset, offset+1); isNotEOF == nil {
+	if fr.r.Buffered() > 0 || r.Len() > 0 {
 		return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: unexpected data after terminator: %w", ErrInvalidTerminator)
 	}
 
-	for _, b := range term {
-		if b != 0x1E {
-			return BinaryAuthContentMessage{}, fmt.Errorf("parseToMessage: invalid character in terminator: %w", ErrInvalidTerminator)
-		}
-	}
-
 	return acm, nil
 }
 
+// ParseIncoming authenticates and parses a frame, given either as the raw bytes
+// of a single frame or as a BinaryAuthContentMessage read by a FrameReader.
 func (au *BinaryAuthContentProtocol) ParseIncoming(data any) (Message, error) {
-	d, ok := data.([]byte)
-	if !ok {
+	var acm BinaryAuthContentMessage
+	switch d := data.(type) {
+	case []byte:
+		var err error
+		if acm, err = au.parseToMessage(d); err != nil {
+			return Message{}, err
+		}
+	case BinaryAuthContentMessage:
+		acm = d
+	default:
 		return Message{}, fmt.Errorf("ParseIncoming: %w", errors.New("invalid data"))
 	}
 
-	acm, err := au.parseToMessage(d)
-	if err != nil {
-		return Message{}, err
-	}
-
 	// Validate Version at some point
 	_ = acm.Version
 
@@ -149,10 +103,35 @@ func (au *BinaryAuthContentProtocol) ParseIncoming(data any) (Message, error) {
 	}
 
 	msg.ConnID = auth.ConnID
+	msg.Auth = auth
 
 	return msg, nil
 }
 
+// EncodeOutgoing encodes msg as a frame with an empty auth section.
+func (au *BinaryAuthContentProtocol) EncodeOutgoing(msg Message) ([]byte, error) {
+	enc := au.Encoder
+	if enc == nil {
+		var ok bool
+		if enc, ok = au.Parser.(Encoder); !ok {
+			return nil, ErrNoEncoder
+		}
+	}
+
+	content, err := enc.Encode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeOutgoing: %w", err)
+	}
+
+	return BinaryAuthContentMessage{
+		Auth:        []byte{},
+		Content:     content,
+		Version:     ProtocolVersion,
+		AuthSize:    0,
+		ContentSize: len(content),
+	}.Marshal()
+}
+
 type AuthParserProtocol struct {
 	Authenticator Authenticator
 	Parser        Parser
@@ -162,6 +141,14 @@ type Parser interface {
 	Parse(data any) (Message, error)
 }
 
+// Encoder is the counterpart to Parser, turning an outgoing Message into the
+// content of a frame.
+type Encoder interface {
+	Encode(msg Message) ([]byte, error)
+}
+
+var ErrNoEncoder = errors.New("protocol has no encoder")
+
 type Authenticator interface {
 	Authenticate(auth any) (AuthInfo, error)
 }
@@ -192,10 +179,16 @@ func NewJWTAuthenticator(opts JWTAuthenticatorOpts) *JWTAuthenticator {
 
 var ErrUnauthorized = errors.New("unauthorized")
 
-// Authenticate takes a token string and returns the claims if the token is valid
+// Authenticate takes a token string, or the raw bytes of one as read from a
+// frame, and returns the claims if the token is valid
 func (j *JWTAuthenticator) Authenticate(data any) (AuthInfo, error) {
-	ts, ok := data.(string)
-	if !ok {
+	var ts string
+	switch d := data.(type) {
+	case string:
+		ts = d
+	case []byte:
+		ts = string(d)
+	default:
 		return AuthInfo{}, fmt.Errorf("authenticate: %w: %s", ErrUnauthorized, "invalid token")
 	}
 
@@ -237,16 +230,3 @@ func IntToBytes(n int, byteCount int) []byte {
 	}
 	return b
 }
-
-func safeByteRange(b []byte, start, end int) ([]byte, error) {
-	if start < 0 || start > len(b) {
-		return nil, fmt.Errorf("safeByteRange: %w", errors.New("start index out of range"))
-	}
-	if end < 0 || end > len(b) {
-		return nil, fmt.Errorf("safeByteRange: %w", errors.New("end index out of range"))
-	}
-	if start > end {
-		return nil, fmt.Errorf("safeByteRange: %w", errors.New("start index greater than end index"))
-	}
-	return b[start:end], nil
-}
@@ -157,6 +157,29 @@ func TestJWTAuthenticator_Authenticate(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "Test JWTAuthenticator Authenticate with token bytes",
+			fields: fields{
+				jwt: &maestro.JWTAuthenticator{
+					Opts: maestro.JWTAuthenticatorOpts{
+						SigningMethod: "HS256",
+						Secret:        "secret",
+					},
+				},
+			},
+			args: args{
+				data: []byte(validTokenString),
+			},
+			want: maestro.AuthInfo{
+				Claims: map[string]any{
+					"name":    "John Doe",
+					"sub":     "1234567890",
+					"conn_id": "1234567890",
+				},
+				ConnID: "1234567890",
+			},
+			wantErr: nil,
+		},
 		{
 			name: "Test JWTAuthenticator Authenticate with invalid secret",
 			fields: fields{
@@ -292,7 +315,32 @@ func TestBinaryAuthContentProtocol_ParseIncoming(t *testing.T) {
 					Content:     []byte("content"),
 				}),
 			},
-			want:    maestro.Message{Content: []byte("content"), ActionType: maestro.ActionTypeSubscribe, ConnID: "12345"},
+			want:    maestro.Message{Content: []byte("content"), ActionType: maestro.ActionTypeSubscribe, ConnID: "12345", Auth: maestro.AuthInfo{ConnID: "12345"}},
+			wantErr: nil,
+		},
+		{
+			name: "Decoded Frame",
+			fields: fields{
+				Authenticator: BinaryAuthTestAuthenticator{
+					Error:  nil,
+					Valid:  true,
+					ConnID: "12345",
+				},
+				Parser: BinaryAuthTestParser{
+					ActionType: maestro.ActionTypeSubscribe,
+					Error:      nil,
+				},
+			},
+			args: args{
+				data: maestro.BinaryAuthContentMessage{
+					Version:     1,
+					AuthSize:    4,
+					Auth:        []byte("auth"),
+					ContentSize: len([]byte("content")),
+					Content:     []byte("content"),
+				},
+			},
+			want:    maestro.Message{Content: []byte("content"), ActionType: maestro.ActionTypeSubscribe, ConnID: "12345", Auth: maestro.AuthInfo{ConnID: "12345"}},
 			wantErr: nil,
 		},
 		{
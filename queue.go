@@ -1,14 +1,523 @@
 package maestro
 
-type QueueConfig struct{}
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultVisibilityTimeout = 30 * time.Second
+	DefaultBackoffMultiplier = 2
+)
+
+type QueueConfig struct {
+	// Compare orders items in priority containers such as HeapContainer.
+	// ComparePriority is used when it is nil.
+	Compare CompareFunc
+	// DeadLetterQueue names the queue that items are moved to once they run
+	// out of delivery attempts or are rejected without requeueing. Such items
+	// are dropped when it is empty.
+	DeadLetterQueue string
+	// Backoff delays redelivery of rejected items.
+	Backoff Backoff
+	// VisibilityTimeout is how long a delivered item may go unacknowledged
+	// before it is requeued for redelivery. DefaultVisibilityTimeout when 0.
+	VisibilityTimeout time.Duration
+	// MaxDeliveryAttempts is how many times an item is delivered before it is
+	// dead-lettered. Deliveries that time out count as attempts. Unlimited
+	// when 0.
+	MaxDeliveryAttempts int
+}
+
+// Backoff is an exponential backoff policy. The first retry waits Initial and
+// each one after that waits Multiplier times longer, up to Max.
+type Backoff struct {
+	Initial time.Duration
+	// Max caps the delay. Uncapped when 0.
+	Max time.Duration
+	// Multiplier is DefaultBackoffMultiplier when 0.
+	Multiplier float64
+}
+
+// Delay returns how long to wait before redelivering an item that has been
+// delivered attempts times.
+func (b Backoff) Delay(attempts int) time.Duration {
+	if b.Initial <= 0 || attempts <= 0 {
+		return 0
+	}
+
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = DefaultBackoffMultiplier
+	}
+
+	delay := float64(b.Initial)
+	for range attempts - 1 {
+		delay *= multiplier
+		if b.Max > 0 && delay >= float64(b.Max) {
+			return b.Max
+		}
+	}
+
+	return time.Duration(delay)
+}
 
 type Queue struct {
 	Container Container
+	Watcher   Watcher
 	Writer    ContainerWriter
-	Cfg       QueueConfig
-	Name      string
+	// cancel stops the queue's goroutines when the queue is deleted.
+	cancel context.CancelFunc
+	// lookup resolves the dead-letter queue.
+	lookup func(name string) (*Queue, error)
+	// settled is called with the ID of an item once it has been acknowledged
+	// or dead-lettered.
+	settled func(q *Queue, id string)
+	// inflight holds items that have been delivered but not yet acknowledged,
+	// keyed by delivery tag.
+	inflight map[string]*Delivery
+	// attempts counts the deliveries of each item by ID until it is
+	// acknowledged, changed or dead-lettered.
+	attempts map[string]int
+	// delayed holds rejected items waiting out their backoff.
+	delayed []*Delivery
+	Cfg     QueueConfig
+	Name    string
+	nextTag uint64
+	mutex   sync.Mutex
+}
+
+// Delivery is an item handed to a consumer that has not been acknowledged yet.
+type Delivery struct {
+	Item     QueueItem
+	Deadline time.Time
+	Tag      string
+	Queue    string
+	ConnID   string
+	// Attempt is 1 on the first delivery of the item and counts up with every
+	// redelivery.
+	Attempt int
+	seq     uint64
+	// superseded is set when the item is updated or deleted while in flight,
+	// so the stale copy is dropped rather than redelivered if it expires.
+	superseded bool
+}
+
+// DeadLetterItem is pushed to a dead-letter queue in place of an item that ran
+// out of delivery attempts or was rejected without requeueing.
+type DeadLetterItem struct {
+	Payload  any    `json:"payload"`
+	ItemID   string `json:"id"`
+	Queue    string `json:"queue"`
+	Reason   string `json:"reason"`
+	Attempts int    `json:"attempts"`
+}
+
+func (d *DeadLetterItem) ID() string {
+	return d.ItemID
+}
+
+// Data returns the dead-letter item itself so consumers of the dead-letter
+// queue receive the failure details along with the original payload.
+func (d *DeadLetterItem) Data() any {
+	return d
 }
 
 type ContainerWriter interface {
 	Write(item QueueItem) error
 }
+
+var (
+	ErrUnknownOpType    = errors.New("unknown op type")
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	ErrNotSyncContainer = errors.New("queue container is not a SyncContainer")
+)
+
+const (
+	ReasonVisibilityTimeout = "visibility timeout expired"
+	ReasonRejected          = "rejected"
+	ReasonDisconnected      = "consumer disconnected"
+)
+
+// Apply reflects a watcher update in the queue's container.
+//
+// Inserts and updates are upserts: an item that is still queued is replaced in
+// place, otherwise it is pushed. An update to an item that is in flight queues
+// the new version, and an update or delete of an in flight item stops the
+// stale copy from being redelivered if its visibility timeout expires. The
+// consumer holding it can still acknowledge it.
+func (q *Queue) Apply(msg QueueUpdateMessage) error {
+	switch msg.OpType {
+	case OpTypeInsert, OpTypeUpdate:
+		q.supersede(msg.ID)
+
+		item := NewQueueItem(msg.ID, msg.Data)
+		err := q.Container.Replace(msg.ID, item)
+		if errors.Is(err, ErrItemNotFound) {
+			q.Container.Push(item)
+			return nil
+		}
+		return err
+	case OpTypeDelete:
+		q.supersede(msg.ID)
+
+		err := q.Container.Remove(msg.ID)
+		if errors.Is(err, ErrItemNotFound) {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("apply: %w: %d", ErrUnknownOpType, msg.OpType)
+	}
+}
+
+// Next blocks until an item is available and records it as delivered to
+// connID. The item stays in flight until it is acknowledged or its
+// visibility timeout expires.
+func (q *Queue) Next(ctx context.Context, connID string) (*Delivery, error) {
+	sc, ok := q.Container.(*SyncContainer)
+	if !ok {
+		return nil, ErrNotSyncContainer
+	}
+
+	item, err := sc.PopWait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.track(item, connID, time.Now()), nil
+}
+
+func (q *Queue) track(item QueueItem, connID string, now time.Time) *Delivery {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.inflight == nil {
+		q.inflight = make(map[string]*Delivery)
+	}
+	if q.attempts == nil {
+		q.attempts = make(map[string]int)
+	}
+	q.attempts[item.ID()]++
+
+	q.nextTag++
+	d := &Delivery{
+		Item:       item,
+		Deadline:   now.Add(q.visibilityTimeout()),
+		Tag:        strconv.FormatUint(q.nextTag, 10),
+		Queue:      q.Name,
+		ConnID:     connID,
+		Attempt:    q.attempts[item.ID()],
+		seq:        q.nextTag,
+		superseded: false,
+	}
+	q.inflight[d.Tag] = d
+
+	return d
+}
+
+// Acknowledge permanently removes a delivered item. Only the connection the
+// item was delivered to may acknowledge it.
+func (q *Queue) Acknowledge(connID string, tag string) error {
+	q.mutex.Lock()
+	d, ok := q.inflight[tag]
+	if !ok || d.ConnID != connID {
+		q.mutex.Unlock()
+		return fmt.Errorf("acknowledge: %w: %s", ErrDeliveryNotFound, tag)
+	}
+	delete(q.inflight, tag)
+	superseded := d.superseded
+	if !superseded {
+		delete(q.attempts, d.Item.ID())
+	}
+	q.mutex.Unlock()
+
+	if !superseded && q.settled != nil {
+		q.settled(q, d.Item.ID())
+	}
+
+	return nil
+}
+
+// Nack rejects a delivered item. With requeue set the item is redelivered once
+// the queue's backoff delay has passed, unless it has used up its delivery
+// attempts. Otherwise, or once it runs out of attempts, it is moved to the
+// dead-letter queue with reason. If the dead-letter queue can't be found the
+// item is requeued and the error returned. Only the connection the item was
+// delivered to may reject it.
+func (q *Queue) Nack(connID string, tag string, reason string, requeue bool) error {
+	now := time.Now()
+
+	q.mutex.Lock()
+	d, ok := q.inflight[tag]
+	if !ok || d.ConnID != connID {
+		q.mutex.Unlock()
+		return fmt.Errorf("nack: %w: %s", ErrDeliveryNotFound, tag)
+	}
+	delete(q.inflight, tag)
+
+	if d.superseded {
+		q.mutex.Unlock()
+		return nil
+	}
+
+	if requeue && !q.exhausted(d) {
+		delay := q.Cfg.Backoff.Delay(d.Attempt)
+		if delay > 0 {
+			d.Deadline = now.Add(delay)
+			q.delayed = append(q.delayed, d)
+			q.mutex.Unlock()
+			return nil
+		}
+		q.mutex.Unlock()
+
+		q.Container.Requeue(d.Item)
+		return nil
+	}
+	q.mutex.Unlock()
+
+	if reason == "" {
+		reason = ReasonRejected
+	}
+	if err := q.deadLetter(d, reason); err != nil {
+		// put the item back rather than lose it
+		q.Container.Requeue(d.Item)
+		return fmt.Errorf("nack: %w", err)
+	}
+
+	return nil
+}
+
+// Release returns a delivered item to the front of the queue straight away,
+// for example when it could not be sent to the consumer. The delivery does not
+// count as an attempt.
+func (q *Queue) Release(tag string) error {
+	q.mutex.Lock()
+	d, ok := q.inflight[tag]
+	delete(q.inflight, tag)
+	if ok && !d.superseded {
+		q.attempts[d.Item.ID()]--
+	}
+	q.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("release: %w: %s", ErrDeliveryNotFound, tag)
+	}
+	if !d.superseded {
+		q.Container.Requeue(d.Item)
+	}
+
+	return nil
+}
+
+// RequeueExpired returns every delivery whose deadline is before now to the
+// front of the queue, oldest delivery first, and reports how many were
+// requeued. This covers deliveries whose visibility timeout expired and
+// rejected items whose backoff has passed. Expired deliveries that have used
+// up their attempts are dead-lettered instead.
+func (q *Queue) RequeueExpired(now time.Time) int {
+	q.mutex.Lock()
+	expired, exhausted := q.takeInflight(func(d *Delivery) bool {
+		return d.Deadline.Before(now)
+	})
+	q.delayed = slices.DeleteFunc(q.delayed, func(d *Delivery) bool {
+		if !d.Deadline.Before(now) {
+			return false
+		}
+		if !d.superseded {
+			expired = append(expired, d)
+		}
+		return true
+	})
+	q.mutex.Unlock()
+
+	return q.requeue(expired, exhausted, ReasonVisibilityTimeout)
+}
+
+// ReleaseConn returns every item in flight to connID to the front of the
+// queue, oldest delivery first, and reports how many were requeued. It is
+// used when the peer unsubscribes, so like Release the deliveries do not
+// count as attempts.
+func (q *Queue) ReleaseConn(connID string) int {
+	q.mutex.Lock()
+	released := []*Delivery{}
+	for tag, d := range q.inflight {
+		if d.ConnID != connID {
+			continue
+		}
+		delete(q.inflight, tag)
+
+		if !d.superseded {
+			q.attempts[d.Item.ID()]--
+			released = append(released, d)
+		}
+	}
+	q.mutex.Unlock()
+
+	return q.requeue(released, nil, "")
+}
+
+// ExpireConn requeues every item in flight to connID as if its visibility
+// timeout had expired, and reports how many were requeued. It is used when
+// the peer disconnects: the deliveries count as attempts, so an item that
+// keeps crashing its consumers is still dead-lettered once it runs out.
+func (q *Queue) ExpireConn(connID string) int {
+	q.mutex.Lock()
+	expired, exhausted := q.takeInflight(func(d *Delivery) bool {
+		return d.ConnID == connID
+	})
+	q.mutex.Unlock()
+
+	return q.requeue(expired, exhausted, ReasonDisconnected)
+}
+
+// takeInflight removes the deliveries matching expire from inflight, split
+// into those to requeue and those that have used up their attempts.
+// Superseded deliveries are dropped. The caller must hold the lock.
+func (q *Queue) takeInflight(expire func(d *Delivery) bool) ([]*Delivery, []*Delivery) {
+	expired := []*Delivery{}
+	exhausted := []*Delivery{}
+	for tag, d := range q.inflight {
+		if !expire(d) {
+			continue
+		}
+		delete(q.inflight, tag)
+
+		switch {
+		case d.superseded:
+		case q.exhausted(d):
+			exhausted = append(exhausted, d)
+		default:
+			expired = append(expired, d)
+		}
+	}
+
+	return expired, exhausted
+}
+
+// requeue dead-letters the exhausted deliveries with reason and returns the
+// rest to the front of the queue, oldest delivery first.
+func (q *Queue) requeue(expired []*Delivery, exhausted []*Delivery, reason string) int {
+	for _, d := range exhausted {
+		// the item is still requeued if the dead-letter queue has gone
+		if err := q.deadLetter(d, reason); err != nil {
+			expired = append(expired, d)
+		}
+	}
+
+	// Requeue pushes to the front, so go newest first to leave the oldest
+	// delivery at the head of the queue.
+	slices.SortFunc(expired, func(a, b *Delivery) int {
+		return cmp.Compare(b.seq, a.seq)
+	})
+	for _, d := range expired {
+		q.Container.Requeue(d.Item)
+	}
+
+	return len(expired)
+}
+
+// InFlight returns the number of delivered items awaiting acknowledgement.
+func (q *Queue) InFlight() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.inflight)
+}
+
+// exhausted reports whether d was the item's last allowed delivery. The caller
+// must hold the lock.
+func (q *Queue) exhausted(d *Delivery) bool {
+	return q.Cfg.MaxDeliveryAttempts > 0 && d.Attempt >= q.Cfg.MaxDeliveryAttempts
+}
+
+// deadLetter moves d to the dead-letter queue, or drops it if the queue has
+// none. If the dead-letter queue can't be found the item is left for the
+// caller to requeue.
+func (q *Queue) deadLetter(d *Delivery, reason string) error {
+	if q.Cfg.DeadLetterQueue == "" || q.lookup == nil {
+		q.forget(d.Item.ID())
+		return nil
+	}
+
+	dlq, err := q.lookup(q.Cfg.DeadLetterQueue)
+	if err != nil {
+		return fmt.Errorf("dead letter: %w", err)
+	}
+
+	dlq.Container.Push(&DeadLetterItem{
+		Payload:  d.Item.Data(),
+		ItemID:   d.Item.ID(),
+		Queue:    q.Name,
+		Reason:   reason,
+		Attempts: d.Attempt,
+	})
+	q.forget(d.Item.ID())
+
+	return nil
+}
+
+func (q *Queue) forget(id string) {
+	q.mutex.Lock()
+	delete(q.attempts, id)
+	q.mutex.Unlock()
+
+	if q.settled != nil {
+		q.settled(q, id)
+	}
+}
+
+// holds reports whether the item is queued, in flight or waiting out its
+// backoff.
+func (q *Queue) holds(id string) bool {
+	if _, err := q.Container.Find(id); err == nil {
+		return true
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, d := range q.inflight {
+		if d.Item.ID() == id && !d.superseded {
+			return true
+		}
+	}
+	for _, d := range q.delayed {
+		if d.Item.ID() == id && !d.superseded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// supersede marks every in flight or delayed copy of the item as stale and
+// resets its delivery attempts, since a changed item starts over.
+func (q *Queue) supersede(id string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, d := range q.inflight {
+		if d.Item.ID() == id {
+			d.superseded = true
+		}
+	}
+	for _, d := range q.delayed {
+		if d.Item.ID() == id {
+			d.superseded = true
+		}
+	}
+	delete(q.attempts, id)
+}
+
+func (q *Queue) visibilityTimeout() time.Duration {
+	if q.Cfg.VisibilityTimeout <= 0 {
+		return DefaultVisibilityTimeout
+	}
+	return q.Cfg.VisibilityTimeout
+}
@@ -0,0 +1,399 @@
+package maestro_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue_Apply(t *testing.T) {
+	tests := []struct {
+		expectedError error
+		name          string
+		messages      []maestro.QueueUpdateMessage
+		expectedItems []maestro.QueueItem
+	}{
+		{
+			name: "Insert Pushes Item",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpTypeInsert, ID: "a", Data: "one"},
+				{OpType: maestro.OpTypeInsert, ID: "b", Data: "two"},
+			},
+			expectedItems: []maestro.QueueItem{
+				maestro.NewQueueItem("a", "one"),
+				maestro.NewQueueItem("b", "two"),
+			},
+		},
+		{
+			name: "Update Replaces Queued Item In Place",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpTypeInsert, ID: "a", Data: "one"},
+				{OpType: maestro.OpTypeInsert, ID: "b", Data: "two"},
+				{OpType: maestro.OpTypeUpdate, ID: "a", Data: "three"},
+			},
+			expectedItems: []maestro.QueueItem{
+				maestro.NewQueueItem("a", "three"),
+				maestro.NewQueueItem("b", "two"),
+			},
+		},
+		{
+			name: "Update Of Unknown Item Pushes It",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpTypeUpdate, ID: "a", Data: "one"},
+			},
+			expectedItems: []maestro.QueueItem{
+				maestro.NewQueueItem("a", "one"),
+			},
+		},
+		{
+			name: "Duplicate Insert Does Not Duplicate Item",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpTypeInsert, ID: "a", Data: "one"},
+				{OpType: maestro.OpTypeInsert, ID: "a", Data: "two"},
+			},
+			expectedItems: []maestro.QueueItem{
+				maestro.NewQueueItem("a", "two"),
+			},
+		},
+		{
+			name: "Delete Removes Item",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpTypeInsert, ID: "a", Data: "one"},
+				{OpType: maestro.OpTypeInsert, ID: "b", Data: "two"},
+				{OpType: maestro.OpTypeDelete, ID: "a"},
+			},
+			expectedItems: []maestro.QueueItem{
+				maestro.NewQueueItem("b", "two"),
+			},
+		},
+		{
+			name: "Delete Of Unknown Item Is A No-Op",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpTypeDelete, ID: "a"},
+			},
+			expectedItems: []maestro.QueueItem{},
+		},
+		{
+			name: "Unknown Op Type",
+			messages: []maestro.QueueUpdateMessage{
+				{OpType: maestro.OpType(99), ID: "a"},
+			},
+			expectedItems: []maestro.QueueItem{},
+			expectedError: maestro.ErrUnknownOpType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &maestro.Queue{
+				Container: maestro.NewSliceContainer(),
+				Name:      "test",
+			}
+
+			var err error
+			for _, msg := range tt.messages {
+				err = q.Apply(msg)
+			}
+			require.ErrorIs(t, err, tt.expectedError)
+			require.Equal(t, tt.expectedItems, q.Container.Items())
+		})
+	}
+}
+
+func newTestQueue(t *testing.T, cfg maestro.QueueConfig) *maestro.Queue {
+	t.Helper()
+
+	m := maestro.New(testConfig())
+	q, err := m.CreateQueue("test", nil, nil, cfg)
+	require.NoError(t, err)
+
+	for _, item := range makeTestQueueItems(3) {
+		q.Container.Push(item)
+	}
+
+	return q
+}
+
+func TestQueue_Acknowledge(t *testing.T) {
+	q := newTestQueue(t, maestro.QueueConfig{})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, testQueueItem(0), d.Item)
+	require.Equal(t, "test", d.Queue)
+	require.Equal(t, 1, q.InFlight())
+
+	require.ErrorIs(t, q.Acknowledge("2", d.Tag), maestro.ErrDeliveryNotFound, "only the receiver may acknowledge")
+	require.NoError(t, q.Acknowledge("1", d.Tag))
+	require.ErrorIs(t, q.Acknowledge("1", d.Tag), maestro.ErrDeliveryNotFound)
+	require.Zero(t, q.InFlight())
+	require.Equal(t, 2, q.Container.Len())
+}
+
+func TestQueue_Release(t *testing.T) {
+	q := newTestQueue(t, maestro.QueueConfig{})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+
+	require.NoError(t, q.Release(d.Tag))
+	require.ErrorIs(t, q.Release(d.Tag), maestro.ErrDeliveryNotFound)
+	require.Zero(t, q.InFlight())
+	require.Equal(t, makeTestQueueItems(3), q.Container.Items())
+}
+
+func TestQueue_RequeueExpired(t *testing.T) {
+	q := newTestQueue(t, maestro.QueueConfig{VisibilityTimeout: time.Minute})
+
+	first, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	second, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+
+	require.Zero(t, q.RequeueExpired(time.Now()))
+	require.Equal(t, 2, q.InFlight())
+
+	require.Equal(t, 2, q.RequeueExpired(second.Deadline.Add(time.Millisecond)))
+	require.Zero(t, q.InFlight())
+	require.Equal(t, makeTestQueueItems(3), q.Container.Items(), "expired items should keep their order")
+
+	// a requeued item gets a new tag, so the stale one can't be acknowledged
+	require.ErrorIs(t, q.Acknowledge("1", first.Tag), maestro.ErrDeliveryNotFound)
+}
+
+func TestQueue_SupersededDelivery(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        maestro.QueueUpdateMessage
+		expectedItems []maestro.QueueItem
+	}{
+		{
+			name:   "Update",
+			update: maestro.QueueUpdateMessage{OpType: maestro.OpTypeUpdate, ID: "testId0", Data: "updated"},
+			expectedItems: []maestro.QueueItem{
+				testQueueItem(1),
+				testQueueItem(2),
+				maestro.NewQueueItem("testId0", "updated"),
+			},
+		},
+		{
+			name:   "Delete",
+			update: maestro.QueueUpdateMessage{OpType: maestro.OpTypeDelete, ID: "testId0"},
+			expectedItems: []maestro.QueueItem{
+				testQueueItem(1),
+				testQueueItem(2),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t, maestro.QueueConfig{})
+
+			d, err := q.Next(context.Background(), "1")
+			require.NoError(t, err)
+			require.NoError(t, q.Apply(tt.update))
+
+			require.Zero(t, q.RequeueExpired(d.Deadline.Add(time.Millisecond)), "stale copy should not be redelivered")
+			require.Equal(t, tt.expectedItems, q.Container.Items())
+		})
+	}
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  maestro.Backoff
+		expected []time.Duration
+	}{
+		{
+			name:     "No Backoff",
+			backoff:  maestro.Backoff{},
+			expected: []time.Duration{0, 0, 0},
+		},
+		{
+			name:     "Default Multiplier",
+			backoff:  maestro.Backoff{Initial: time.Second},
+			expected: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:     "Capped",
+			backoff:  maestro.Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 3},
+			expected: []time.Duration{time.Second, 3 * time.Second, 5 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.expected {
+				require.Equal(t, want, tt.backoff.Delay(i+1), "attempt %d", i+1)
+			}
+		})
+	}
+}
+
+func newDeadLetterTest(t *testing.T, cfg maestro.QueueConfig) (*maestro.Queue, *maestro.Queue) {
+	t.Helper()
+
+	m := maestro.New(testConfig())
+	dlq, err := m.CreateQueue("dead", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	cfg.DeadLetterQueue = "dead"
+	q, err := m.CreateQueue("test", nil, nil, cfg)
+	require.NoError(t, err)
+	q.Container.Push(testQueueItem(0))
+
+	return q, dlq
+}
+
+func TestQueue_Nack(t *testing.T) {
+	q, dlq := newDeadLetterTest(t, maestro.QueueConfig{MaxDeliveryAttempts: 2})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, 1, d.Attempt)
+
+	require.ErrorIs(t, q.Nack("2", d.Tag, "", true), maestro.ErrDeliveryNotFound, "only the receiver may reject")
+	require.NoError(t, q.Nack("1", d.Tag, "", true))
+	require.Equal(t, []maestro.QueueItem{testQueueItem(0)}, q.Container.Items(), "no backoff requeues straight away")
+
+	d, err = q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, 2, d.Attempt)
+
+	require.NoError(t, q.Nack("1", d.Tag, "bad payload", true))
+	require.Zero(t, q.Container.Len())
+	require.Zero(t, q.InFlight())
+	require.Equal(t, []maestro.QueueItem{&maestro.DeadLetterItem{
+		Payload:  "testData0",
+		ItemID:   "testId0",
+		Queue:    "test",
+		Reason:   "bad payload",
+		Attempts: 2,
+	}}, dlq.Container.Items())
+}
+
+func TestQueue_NackWithoutRequeue(t *testing.T) {
+	q, dlq := newDeadLetterTest(t, maestro.QueueConfig{})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.NoError(t, q.Nack("1", d.Tag, "", false))
+
+	require.Zero(t, q.Container.Len())
+	require.Equal(t, []maestro.QueueItem{&maestro.DeadLetterItem{
+		Payload:  "testData0",
+		ItemID:   "testId0",
+		Queue:    "test",
+		Reason:   maestro.ReasonRejected,
+		Attempts: 1,
+	}}, dlq.Container.Items())
+}
+
+func TestQueue_NackMissingDeadLetterQueue(t *testing.T) {
+	m := maestro.New(testConfig())
+	q, err := m.CreateQueue("test", nil, nil, maestro.QueueConfig{DeadLetterQueue: "missing"})
+	require.NoError(t, err)
+	q.Container.Push(testQueueItem(0))
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.ErrorIs(t, q.Nack("1", d.Tag, "", false), maestro.ErrQueueNotFound)
+
+	require.Zero(t, q.InFlight())
+	require.Equal(t, []maestro.QueueItem{testQueueItem(0)}, q.Container.Items())
+}
+
+func TestQueue_NackBackoff(t *testing.T) {
+	q, _ := newDeadLetterTest(t, maestro.QueueConfig{
+		Backoff: maestro.Backoff{Initial: time.Minute},
+	})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.NoError(t, q.Nack("1", d.Tag, "", true))
+	require.Zero(t, q.Container.Len(), "item should wait out its backoff")
+	require.ErrorIs(t, q.Acknowledge("1", d.Tag), maestro.ErrDeliveryNotFound)
+
+	require.Zero(t, q.RequeueExpired(time.Now()))
+	require.Equal(t, 1, q.RequeueExpired(time.Now().Add(time.Minute+time.Second)))
+	require.Equal(t, []maestro.QueueItem{testQueueItem(0)}, q.Container.Items())
+}
+
+func TestQueue_VisibilityTimeoutCountsAsAttempt(t *testing.T) {
+	q, dlq := newDeadLetterTest(t, maestro.QueueConfig{MaxDeliveryAttempts: 2})
+
+	for range 2 {
+		d, err := q.Next(context.Background(), "1")
+		require.NoError(t, err)
+		q.RequeueExpired(d.Deadline.Add(time.Millisecond))
+	}
+
+	require.Zero(t, q.Container.Len())
+	require.Zero(t, q.InFlight())
+	require.Equal(t, []maestro.QueueItem{&maestro.DeadLetterItem{
+		Payload:  "testData0",
+		ItemID:   "testId0",
+		Queue:    "test",
+		Reason:   maestro.ReasonVisibilityTimeout,
+		Attempts: 2,
+	}}, dlq.Container.Items())
+}
+
+func TestQueue_ReleaseIsNotAnAttempt(t *testing.T) {
+	q, _ := newDeadLetterTest(t, maestro.QueueConfig{MaxDeliveryAttempts: 1})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.NoError(t, q.Release(d.Tag))
+
+	d, err = q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, 1, d.Attempt)
+}
+
+func TestQueue_ReleaseConn(t *testing.T) {
+	q, _ := newDeadLetterTest(t, maestro.QueueConfig{MaxDeliveryAttempts: 1})
+	q.Container.Push(testQueueItem(1))
+	q.Container.Push(testQueueItem(2))
+
+	for _, connID := range []string{"1", "2", "1"} {
+		_, err := q.Next(context.Background(), connID)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 2, q.ReleaseConn("1"))
+	require.Zero(t, q.ReleaseConn("1"))
+	require.Equal(t, 1, q.InFlight())
+	require.Equal(t, []maestro.QueueItem{testQueueItem(0), testQueueItem(2)}, q.Container.Items())
+
+	// released deliveries are not attempts
+	d, err := q.Next(context.Background(), "2")
+	require.NoError(t, err)
+	require.Equal(t, 1, d.Attempt)
+}
+
+func TestQueue_ExpireConn(t *testing.T) {
+	q, dlq := newDeadLetterTest(t, maestro.QueueConfig{MaxDeliveryAttempts: 2})
+
+	d, err := q.Next(context.Background(), "1")
+	require.NoError(t, err)
+	require.Zero(t, q.ExpireConn("2"))
+	require.Equal(t, 1, q.ExpireConn("1"))
+	require.Equal(t, []maestro.QueueItem{d.Item}, q.Container.Items())
+
+	_, err = q.Next(context.Background(), "2")
+	require.NoError(t, err)
+	require.Zero(t, q.ExpireConn("2"))
+
+	require.Zero(t, q.Container.Len())
+	require.Zero(t, q.InFlight())
+	require.Equal(t, []maestro.QueueItem{&maestro.DeadLetterItem{
+		Payload:  "testData0",
+		ItemID:   "testId0",
+		Queue:    "test",
+		Reason:   maestro.ReasonDisconnected,
+		Attempts: 2,
+	}}, dlq.Container.Items())
+}
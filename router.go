@@ -0,0 +1,242 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RouteFunc picks the queue an update belongs in. It reports false when it
+// can't tell.
+type RouteFunc func(msg QueueUpdateMessage) (string, bool)
+
+// Router feeds the updates from a single watcher, such as a MongoWatcher on a
+// whole database, into many queues.
+type Router struct {
+	Watcher Watcher
+	Route   RouteFunc
+	// cancel stops the router's watcher when it is deleted.
+	cancel context.CancelFunc
+	// placed remembers the queue each item was last routed to, keyed by ID
+	// and namespace, so that deletes can be routed without a document and
+	// items an update routes elsewhere can be moved. Entries are dropped once
+	// the item is acknowledged or dead-lettered.
+	placed map[string]map[string]string
+	Name   string
+	mutex  sync.Mutex
+}
+
+var (
+	ErrRouterExists   = errors.New("router already exists")
+	ErrRouterNotFound = errors.New("router not found")
+	ErrUnroutable     = errors.New("update can't be routed")
+)
+
+// RouteByNamespace routes updates by the collection or table they came from,
+// to the queue named for it in routes. When routes is nil each namespace goes
+// to the queue of the same name.
+func RouteByNamespace(routes map[string]string) RouteFunc {
+	return func(msg QueueUpdateMessage) (string, bool) {
+		if routes == nil {
+			return msg.Namespace, msg.Namespace != ""
+		}
+
+		name, ok := routes[msg.Namespace]
+		return name, ok
+	}
+}
+
+// RouteByField routes updates to the queue named by a string field of their
+// data, given as a dot separated path such as "meta.queue". Deletes, which
+// usually have no data, are routed by QueueUpdateMessage.Before if the watcher
+// provides it.
+func RouteByField(field string) RouteFunc {
+	path := strings.Split(field, ".")
+
+	return func(msg QueueUpdateMessage) (string, bool) {
+		if name, ok := lookupField(msg.Data, path); ok {
+			return name, true
+		}
+		return lookupField(msg.Before, path)
+	}
+}
+
+func lookupField(data any, path []string) (string, bool) {
+	value, _ := lookupValue(data, path)
+	name, ok := value.(string)
+	return name, ok && name != ""
+}
+
+// lookupValue follows path through nested documents in data.
+func lookupValue(data any, path []string) (any, bool) {
+	for _, key := range path {
+		var ok bool
+		switch doc := data.(type) {
+		case map[string]any:
+			data, ok = doc[key]
+		case bson.M:
+			data, ok = doc[key]
+		default:
+			return nil, false
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return data, true
+}
+
+// CreateRouter registers a router that applies each update from w to the
+// queue route picks. The queues must be created separately. Updates that can't
+// be routed are logged and dropped, except that an item is always deleted from,
+// or moved out of, the queue it was last routed to. Once an item has been
+// acknowledged or dead-lettered, deletes of it that can't be routed are ignored.
+func (m *Maestro) CreateRouter(name string, w Watcher, route RouteFunc) (*Router, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.routers[name]; ok {
+		return nil, fmt.Errorf("createRouter: %w: %s", ErrRouterExists, name)
+	}
+
+	r := &Router{
+		Watcher: w,
+		Route:   route,
+		cancel:  nil,
+		placed:  make(map[string]map[string]string),
+		Name:    name,
+		mutex:   sync.Mutex{},
+	}
+	m.routers[name] = r
+
+	if m.runCtx != nil {
+		m.startRouter(m.runCtx, r)
+	}
+
+	return r, nil
+}
+
+// DeleteRouter stops the router's watcher and removes it. Its queues are left
+// as they are.
+func (m *Maestro) DeleteRouter(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r, ok := m.routers[name]
+	if !ok {
+		return fmt.Errorf("deleteRouter: %w: %s", ErrRouterNotFound, name)
+	}
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+	delete(m.routers, name)
+
+	return nil
+}
+
+// startRouter runs the router's watcher in the background. The caller must
+// hold the lock.
+func (m *Maestro) startRouter(ctx context.Context, r *Router) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+
+	m.spawn(ctx, "router", r.Name, func(ctx context.Context) error {
+		return m.pump(ctx, r.Watcher, func(msg QueueUpdateMessage) error {
+			return m.route(r, msg)
+		}, slog.String("router", r.Name))
+	})
+}
+
+// route applies msg to the queue r routes it to, removing the item from the
+// queue it was previously routed to if that has changed.
+func (m *Maestro) route(r *Router, msg QueueUpdateMessage) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	prev, placed := r.placed[msg.ID][msg.Namespace]
+
+	name, ok := r.Route(msg)
+	switch {
+	case ok:
+	case placed:
+		name = prev
+	case msg.OpType == OpTypeDelete:
+		// the item isn't in any of the router's queues, such as once it has
+		// been acknowledged, so there is nothing to delete
+		return nil
+	default:
+		return fmt.Errorf("route: %w: %s", ErrUnroutable, msg.ID)
+	}
+
+	if placed && prev != name {
+		if q, err := m.Queue(prev); err == nil {
+			if err := q.Apply(QueueUpdateMessage{OpType: OpTypeDelete, ID: msg.ID, Namespace: msg.Namespace}); err != nil {
+				return fmt.Errorf("route: %w", err)
+			}
+		}
+		r.unplace(msg.ID, msg.Namespace)
+	}
+
+	q, err := m.Queue(name)
+	if err != nil {
+		return fmt.Errorf("route: %w", err)
+	}
+
+	if msg.OpType == OpTypeDelete {
+		r.unplace(msg.ID, msg.Namespace)
+	} else {
+		if r.placed[msg.ID] == nil {
+			r.placed[msg.ID] = make(map[string]string)
+		}
+		r.placed[msg.ID][msg.Namespace] = name
+	}
+
+	return q.Apply(msg)
+}
+
+// settled is called by q once the item with id has left it for good, and
+// makes every router forget that it placed the item there.
+func (m *Maestro) settled(q *Queue, id string) {
+	m.mutex.Lock()
+	routers := make([]*Router, 0, len(m.routers))
+	for _, r := range m.routers {
+		routers = append(routers, r)
+	}
+	m.mutex.Unlock()
+
+	for _, r := range routers {
+		r.forget(q, id)
+	}
+}
+
+// forget drops the placements of id in q, unless an update routed there since
+// has put the item back.
+func (r *Router) forget(q *Queue, id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.placed[id]) == 0 || q.holds(id) {
+		return
+	}
+
+	for ns, name := range r.placed[id] {
+		if name == q.Name {
+			r.unplace(id, ns)
+		}
+	}
+}
+
+// unplace drops the placement of the item. The caller must hold the lock.
+func (r *Router) unplace(id string, namespace string) {
+	delete(r.placed[id], namespace)
+	if len(r.placed[id]) == 0 {
+		delete(r.placed, id)
+	}
+}
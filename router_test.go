@@ -0,0 +1,208 @@
+package maestro_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRouteByNamespace(t *testing.T) {
+	tests := []struct {
+		routes    map[string]string
+		name      string
+		namespace string
+		want      string
+		wantOK    bool
+	}{
+		{
+			name:      "Mapped Namespace",
+			routes:    map[string]string{"orders": "order-queue"},
+			namespace: "orders",
+			want:      "order-queue",
+			wantOK:    true,
+		},
+		{
+			name:      "Unmapped Namespace",
+			routes:    map[string]string{"orders": "order-queue"},
+			namespace: "invoices",
+			wantOK:    false,
+		},
+		{
+			name:      "Namespace Is Queue Name",
+			namespace: "invoices",
+			want:      "invoices",
+			wantOK:    true,
+		},
+		{
+			name:   "Missing Namespace",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := maestro.RouteByNamespace(tt.routes)(maestro.QueueUpdateMessage{Namespace: tt.namespace})
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRouteByField(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   string
+		msg    maestro.QueueUpdateMessage
+		wantOK bool
+	}{
+		{
+			name:   "Map",
+			msg:    maestro.QueueUpdateMessage{Data: map[string]any{"meta": map[string]any{"queue": "orders"}}},
+			want:   "orders",
+			wantOK: true,
+		},
+		{
+			name:   "BSON Document",
+			msg:    maestro.QueueUpdateMessage{Data: bson.M{"meta": bson.M{"queue": "orders"}}},
+			want:   "orders",
+			wantOK: true,
+		},
+		{
+			name: "Before Change",
+			msg: maestro.QueueUpdateMessage{
+				OpType: maestro.OpTypeDelete,
+				Before: bson.M{"meta": bson.M{"queue": "orders"}},
+			},
+			want:   "orders",
+			wantOK: true,
+		},
+		{
+			name:   "Missing Field",
+			msg:    maestro.QueueUpdateMessage{Data: bson.M{"meta": bson.M{}}},
+			wantOK: false,
+		},
+		{
+			name:   "Not A String",
+			msg:    maestro.QueueUpdateMessage{Data: bson.M{"meta": bson.M{"queue": 1}}},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := maestro.RouteByField("meta.queue")(tt.msg)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMaestro_CreateRouter(t *testing.T) {
+	m := maestro.New(testConfig())
+
+	_, err := m.CreateRouter("db", maestro.NewMemoryWatcher(), maestro.RouteByNamespace(nil))
+	require.NoError(t, err)
+	_, err = m.CreateRouter("db", maestro.NewMemoryWatcher(), maestro.RouteByNamespace(nil))
+	require.ErrorIs(t, err, maestro.ErrRouterExists)
+
+	require.NoError(t, m.DeleteRouter("db"))
+	require.ErrorIs(t, m.DeleteRouter("db"), maestro.ErrRouterNotFound)
+}
+
+func TestMaestro_Router(t *testing.T) {
+	m := maestro.New(testConfig())
+	pending, err := m.CreateQueue("pending", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+	ready, err := m.CreateQueue("ready", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	w := maestro.NewMemoryWatcher()
+	_, err = m.CreateRouter("orders", w, maestro.RouteByField("status"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: "a", Namespace: "orders", Data: bson.M{"status": "pending"}})
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: "b", Namespace: "orders", Data: bson.M{"status": "pending"}})
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: "c", Namespace: "orders", Data: bson.M{"status": "unknown"}})
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: "d", Namespace: "orders", Data: bson.M{}})
+
+	// an update that changes the routing field moves the item
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeUpdate, ID: "a", Namespace: "orders", Data: bson.M{"status": "ready"}})
+	// a delete without a document goes to the queue the item was routed to
+	w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeDelete, ID: "b", Namespace: "orders"})
+
+	require.Eventually(t, func() bool {
+		return ready.Container.Len() == 1 && pending.Container.Len() == 0
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []maestro.QueueItem{maestro.NewQueueItem("a", bson.M{"status": "ready"})}, ready.Container.Items())
+}
+
+func TestMaestro_RouterForgetsSettledItems(t *testing.T) {
+	m := maestro.New(testConfig())
+	pending, err := m.CreateQueue("pending", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+	ready, err := m.CreateQueue("ready", nil, nil, maestro.QueueConfig{})
+	require.NoError(t, err)
+
+	w := maestro.NewMemoryWatcher()
+	r, err := m.CreateRouter("orders", w, maestro.RouteByField("status"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	insert := func(id string, status string) {
+		w.Publish(maestro.QueueUpdateMessage{OpType: maestro.OpTypeInsert, ID: id, Namespace: "orders", Data: bson.M{"status": status}})
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		insert(id, "pending")
+	}
+	require.Eventually(t, func() bool {
+		return pending.Container.Len() == 3
+	}, time.Second, time.Millisecond)
+	require.Equal(t, 3, r.Placed())
+
+	a, err := pending.Next(ctx, "1")
+	require.NoError(t, err)
+	require.NoError(t, pending.Acknowledge("1", a.Tag))
+	require.Equal(t, 2, r.Placed())
+
+	b, err := pending.Next(ctx, "1")
+	require.NoError(t, err)
+	require.NoError(t, pending.Nack("1", b.Tag, "", false))
+	require.Equal(t, 1, r.Placed())
+
+	// acknowledging a stale copy keeps the newer version's placement
+	c, err := pending.Next(ctx, "1")
+	require.NoError(t, err)
+	insert("c", "pending")
+	require.Eventually(t, func() bool {
+		return pending.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+	require.NoError(t, pending.Acknowledge("1", c.Tag))
+	require.Equal(t, 1, r.Placed())
+
+	insert("c", "ready")
+	require.Eventually(t, func() bool {
+		return ready.Container.Len() == 1
+	}, time.Second, time.Millisecond)
+	require.Zero(t, pending.Container.Len())
+}
@@ -2,67 +2,413 @@ package maestro
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Server struct {
 	Logger   slog.Logger
 	Listener net.Listener
+	Protocol Protocol
+	Handler  Handler
+	Peers    *PeerMap
+	sessions map[string]*Session
+	cancel   context.CancelFunc
 	Opts     ServerOpts
+	wg       sync.WaitGroup
+	nextID   atomic.Uint64
+	mutex    sync.Mutex
 }
 
 type ServerOpts struct {
-	Addr string
-	Port int
+	// Protocol decodes incoming frames, typically a BinaryAuthContentProtocol
+	// using a pb.ProtobufParser.
+	Protocol Protocol
+	// Handler receives every message parsed from a connection.
+	Handler Handler
+	// Peers tracks connected peers. A new PeerMap is used when nil.
+	Peers *PeerMap
+	Addr  string
+	// Frame limits the size of incoming frames and decides whether a session
+	// survives a malformed frame or is closed.
+	Frame FrameReaderOpts
+	Port  int
+	// WriteTimeout bounds each write to a session, so a peer that stops
+	// reading can't hold up deliveries to other peers. The session is closed
+	// when a write times out. Defaults to DefaultWriteTimeout.
+	WriteTimeout time.Duration
 }
 
+const DefaultWriteTimeout = 10 * time.Second
+
+// Handler processes a message received on a session. msg.ConnID is set to the
+// ID of the session the message arrived on, which is also the key of its Peer
+// in the server's PeerMap.
+type Handler interface {
+	Handle(ctx context.Context, s *Session, msg Message) error
+}
+
+// DisconnectHandler is implemented by Handlers that need to know when a
+// session ends. HandleDisconnect is called once the session's Peer has been
+// removed from the server's PeerMap.
+type DisconnectHandler interface {
+	HandleDisconnect(connID string)
+}
+
+type HandlerFunc func(ctx context.Context, s *Session, msg Message) error
+
+func (f HandlerFunc) Handle(ctx context.Context, s *Session, msg Message) error {
+	return f(ctx, s, msg)
+}
+
+var (
+	ErrNoProtocol   = errors.New("server has no protocol")
+	ErrWriteTimeout = errors.New("write to peer timed out")
+)
+
 func NewServer(l net.Listener, opts ServerOpts) *Server {
+	peers := opts.Peers
+	if peers == nil {
+		peers = NewPeerMap()
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+
 	s := &Server{
 		Opts:     opts,
 		Logger:   *slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{})),
 		Listener: l,
+		Protocol: opts.Protocol,
+		Handler:  opts.Handler,
+		Peers:    peers,
+		sessions: make(map[string]*Session),
+		cancel:   nil,
+		wg:       sync.WaitGroup{},
+		nextID:   atomic.Uint64{},
+		mutex:    sync.Mutex{},
 	}
 
 	return s
 }
 
-func (s *Server) Start(ctx context.Context) {
+// Start accepts connections in the background until ctx is cancelled or
+// Shutdown is called.
+func (s *Server) Start(ctx context.Context) error {
+	if s.Protocol == nil {
+		return ErrNoProtocol
+	}
+
 	s.Logger.Info("starting server", slog.String("addr", s.Opts.Addr), slog.Int("port", s.Opts.Port))
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.mutex.Lock()
+	s.cancel = cancel
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go s.acceptLoop(ctx)
+
 	s.Logger.Info("server started", slog.String("addr", s.Opts.Addr), slog.Int("port", s.Opts.Port))
 
 	go func() {
 		<-ctx.Done()
 		s.Logger.Info("stopping server")
-		s.Listener.Close()
+		s.close()
+	}()
+
+	return nil
+}
+
+// Wait blocks until the accept loop and every session have finished.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Shutdown stops accepting connections, closes every open session and waits
+// for them to finish.
+func (s *Server) Shutdown() error {
+	s.mutex.Lock()
+	cancel := s.cancel
+	s.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	err := s.close()
+	s.Wait()
+
+	return err
+}
+
+func (s *Server) close() error {
+	err := s.Listener.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, sess := range s.sessions {
+		sess.Close()
+	}
+
+	return err
+}
+
+func (s *Server) acceptLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				return
+			}
+
+			s.Logger.Error("failed to accept connection", slog.String("error", err.Error()))
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		s.serve(ctx, conn)
+	}
+}
+
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	sess := &Session{
+		conn:   conn,
+		server: s,
+		id:     strconv.FormatUint(s.nextID.Add(1), 10),
+		mutex:  sync.Mutex{},
+	}
+
+	s.mutex.Lock()
+	if ctx.Err() != nil {
+		s.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	s.sessions[sess.id] = sess
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	s.Peers.AddPeer(sess.id, &Peer{
+		Session: sess,
+		Auth:    AuthInfo{},
+		queues:  make(map[string]struct{}),
+		ConnID:  sess.id,
+	})
+
+	go func() {
+		defer s.wg.Done()
+		defer s.removeSession(sess)
+
+		sess.run(ctx)
 	}()
 }
 
-type Peer struct{}
+func (s *Server) removeSession(sess *Session) {
+	sess.Close()
+	s.Peers.RemovePeer(sess.id)
+	if h, ok := s.Handler.(DisconnectHandler); ok {
+		h.HandleDisconnect(sess.id)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, sess.id)
+}
+
+// Session is a single client connection to the Server.
+type Session struct {
+	conn   net.Conn
+	server *Server
+	id     string
+	mutex  sync.Mutex
+}
+
+func (sess *Session) ID() string {
+	return sess.id
+}
+
+func (sess *Session) RemoteAddr() net.Addr {
+	return sess.conn.RemoteAddr()
+}
+
+// Write sends raw bytes to the peer. It is safe to call from multiple goroutines.
+// A write that takes longer than the server's WriteTimeout may have sent part
+// of b, so the session is closed and ErrWriteTimeout returned.
+func (sess *Session) Write(b []byte) (int, error) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if err := sess.conn.SetWriteDeadline(time.Now().Add(sess.server.Opts.WriteTimeout)); err != nil {
+		return 0, err
+	}
+
+	n, err := sess.conn.Write(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		_ = sess.conn.Close()
+		return n, fmt.Errorf("%w: %w", ErrWriteTimeout, err)
+	}
+	return n, err
+}
+
+// Send writes m to the peer as a single frame.
+func (sess *Session) Send(m BinaryAuthContentMessage) error {
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	_, err = sess.Write(b)
+	return err
+}
+
+// SendMessage encodes msg with the server's protocol and writes it to the peer.
+func (sess *Session) SendMessage(msg Message) error {
+	b, err := sess.server.Protocol.EncodeOutgoing(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = sess.Write(b)
+	return err
+}
+
+func (sess *Session) Close() error {
+	return sess.conn.Close()
+}
+
+func (sess *Session) run(ctx context.Context) {
+	logger := sess.server.Logger.With(slog.String("conn_id", sess.id))
+	logger.Info("peer connected", slog.String("remote_addr", sess.RemoteAddr().String()))
+	defer logger.Info("peer disconnected")
+
+	fr := NewFrameReader(sess.conn, sess.server.Opts.Frame)
+	for {
+		frame, err := fr.ReadFrame()
+		if IsMalformedFrame(err) && sess.server.Opts.Frame.Resync {
+			logger.Warn("skipped malformed frame", slog.String("error", err.Error()))
+			continue
+		} else if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logger.Error("failed to read frame", slog.String("error", err.Error()))
+			}
+			return
+		}
+
+		msg, err := sess.server.Protocol.ParseIncoming(frame)
+		if err != nil {
+			logger.Error("failed to parse message", slog.String("error", err.Error()))
+			reply := NewErrorReply("", err)
+			if reply.Code == ErrorCodeUnknown {
+				reply.Code = ErrorCodeInvalidMessage
+			}
+			sess.replyError(logger, reply)
+			continue
+		}
+		msg.ConnID = sess.id
+
+		if err := sess.server.Peers.SetAuth(sess.id, msg.Auth); err != nil {
+			logger.Error("failed to record peer auth", slog.String("error", err.Error()))
+		}
+
+		if sess.server.Handler == nil {
+			logger.Warn("no handler for message", slog.String("action", string(msg.ActionType)))
+			continue
+		}
+
+		if err := sess.server.Handler.Handle(ctx, sess, msg); err != nil {
+			logger.Error("failed to handle message", slog.String("action", string(msg.ActionType)), slog.String("error", err.Error()))
+			sess.replyError(logger, NewErrorReply(requestID(msg.Content), err))
+		}
+	}
+}
+
+// replyError tells the peer its message failed. Protocols that can't encode
+// an ErrorReply send nothing.
+func (sess *Session) replyError(logger *slog.Logger, reply *ErrorReply) {
+	err := sess.SendMessage(Message{
+		Content:    reply,
+		Auth:       AuthInfo{},
+		ConnID:     sess.id,
+		ActionType: ActionTypeError,
+	})
+	if err != nil {
+		logger.Debug("failed to send error reply", slog.String("error", err.Error()))
+	}
+}
+
+// Peer is a connected client along with the claims it authenticated with and
+// the queues it is subscribed to.
+type Peer struct {
+	Session *Session
+	Auth    AuthInfo
+	queues  map[string]struct{}
+	ConnID  string
+}
 
+var (
+	ErrPeerNotFound  = errors.New("peer not found")
+	ErrNotSubscribed = errors.New("peer is not subscribed to queue")
+)
+
+// PeerMap tracks connected peers by connection ID and indexes them by the
+// queues they subscribe to.
 type PeerMap struct {
-	peers map[string]*Peer
-	mutex sync.RWMutex
+	peers       map[string]*Peer
+	subscribers map[string]map[string]*Peer
+	// changed is closed and replaced whenever a subscription changes.
+	changed chan struct{}
+	mutex   sync.RWMutex
 }
 
 func NewPeerMap() *PeerMap {
 	return &PeerMap{
-		peers: make(map[string]*Peer),
-		mutex: sync.RWMutex{},
+		peers:       make(map[string]*Peer),
+		subscribers: make(map[string]map[string]*Peer),
+		changed:     make(chan struct{}),
+		mutex:       sync.RWMutex{},
 	}
 }
 
 func (pm *PeerMap) AddPeer(connID string, peer *Peer) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
+
+	peer.ConnID = connID
+	if peer.queues == nil {
+		peer.queues = make(map[string]struct{})
+	}
 	pm.peers[connID] = peer
 }
 
+// RemovePeer removes the peer along with all of its subscriptions.
 func (pm *PeerMap) RemovePeer(connID string) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
+
+	peer, ok := pm.peers[connID]
+	if !ok {
+		return
+	}
+
+	for queue := range peer.queues {
+		pm.unsubscribe(connID, queue)
+	}
 	delete(pm.peers, connID)
 }
 
@@ -71,3 +417,140 @@ func (pm *PeerMap) GetPeer(connID string) *Peer {
 	defer pm.mutex.RUnlock()
 	return pm.peers[connID]
 }
+
+func (pm *PeerMap) Len() int {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return len(pm.peers)
+}
+
+// SetAuth records the claims the peer most recently authenticated with.
+func (pm *PeerMap) SetAuth(connID string, auth AuthInfo) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peer, ok := pm.peers[connID]
+	if !ok {
+		return fmt.Errorf("setAuth: %w: %s", ErrPeerNotFound, connID)
+	}
+	peer.Auth = auth
+
+	return nil
+}
+
+// Subscribe attaches the peer to queue. Subscribing twice is a no-op.
+func (pm *PeerMap) Subscribe(connID string, queue string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peer, ok := pm.peers[connID]
+	if !ok {
+		return fmt.Errorf("subscribe: %w: %s", ErrPeerNotFound, connID)
+	}
+
+	peer.queues[queue] = struct{}{}
+	if _, ok := pm.subscribers[queue]; !ok {
+		pm.subscribers[queue] = make(map[string]*Peer)
+	}
+	pm.subscribers[queue][connID] = peer
+	pm.notify()
+
+	return nil
+}
+
+// IsSubscribed reports whether the peer is subscribed to queue.
+func (pm *PeerMap) IsSubscribed(connID string, queue string) bool {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	_, ok := pm.subscribers[queue][connID]
+	return ok
+}
+
+// Changed returns a channel that is closed the next time a subscription is
+// added or removed.
+func (pm *PeerMap) Changed() <-chan struct{} {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return pm.changed
+}
+
+// Unsubscribe detaches the peer from queue.
+func (pm *PeerMap) Unsubscribe(connID string, queue string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	peer, ok := pm.peers[connID]
+	if !ok {
+		return fmt.Errorf("unsubscribe: %w: %s", ErrPeerNotFound, connID)
+	}
+	if _, ok := peer.queues[queue]; !ok {
+		return fmt.Errorf("unsubscribe: %w: %s", ErrNotSubscribed, queue)
+	}
+
+	pm.unsubscribe(connID, queue)
+	return nil
+}
+
+// RemoveQueue drops every subscription to queue.
+func (pm *PeerMap) RemoveQueue(queue string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	for connID := range pm.subscribers[queue] {
+		pm.unsubscribe(connID, queue)
+	}
+}
+
+// Subscribers returns the peers subscribed to queue ordered by connection ID.
+func (pm *PeerMap) Subscribers(queue string) []*Peer {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	peers := make([]*Peer, 0, len(pm.subscribers[queue]))
+	for _, peer := range pm.subscribers[queue] {
+		peers = append(peers, peer)
+	}
+	slices.SortFunc(peers, func(a, b *Peer) int {
+		return strings.Compare(a.ConnID, b.ConnID)
+	})
+
+	return peers
+}
+
+// Subscriptions returns the names of the queues the peer is subscribed to.
+func (pm *PeerMap) Subscriptions(connID string) []string {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	peer, ok := pm.peers[connID]
+	if !ok {
+		return []string{}
+	}
+	queues := make([]string, 0, len(peer.queues))
+	for queue := range peer.queues {
+		queues = append(queues, queue)
+	}
+	slices.Sort(queues)
+
+	return queues
+}
+
+// unsubscribe removes a subscription from both indexes. The caller must hold the lock.
+func (pm *PeerMap) unsubscribe(connID string, queue string) {
+	if peer, ok := pm.peers[connID]; ok {
+		delete(peer.queues, queue)
+	}
+
+	delete(pm.subscribers[queue], connID)
+	if len(pm.subscribers[queue]) == 0 {
+		delete(pm.subscribers, queue)
+	}
+	pm.notify()
+}
+
+// notify wakes everything waiting on Changed. The caller must hold the lock.
+func (pm *PeerMap) notify() {
+	close(pm.changed)
+	pm.changed = make(chan struct{})
+}
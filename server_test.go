@@ -0,0 +1,314 @@
+package maestro_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+type testServer struct {
+	*maestro.Server
+	messages chan maestro.Message
+}
+
+func startTestServer(t *testing.T, opts maestro.ServerOpts) *testServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ts := &testServer{
+		messages: make(chan maestro.Message, 16),
+	}
+
+	if opts.Protocol == nil {
+		opts.Protocol = &maestro.BinaryAuthContentProtocol{
+			Authenticator: maestro.NewNilAuthenticator(),
+			Parser:        BinaryAuthTestParser{ActionType: maestro.ActionTypeSubscribe},
+		}
+	}
+	if opts.Handler == nil {
+		opts.Handler = maestro.HandlerFunc(func(_ context.Context, _ *maestro.Session, msg maestro.Message) error {
+			ts.messages <- msg
+			return nil
+		})
+	}
+
+	ts.Server = maestro.NewServer(l, opts)
+	ts.Server.Logger = *slog.New(slog.NewTextHandler(io.Discard, nil))
+	require.NoError(t, ts.Start(context.Background()))
+	t.Cleanup(func() {
+		require.NoError(t, ts.Shutdown())
+	})
+
+	return ts
+}
+
+func (ts *testServer) dial(t *testing.T) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return conn
+}
+
+func (ts *testServer) nextMessage(t *testing.T) maestro.Message {
+	t.Helper()
+
+	select {
+	case msg := <-ts.messages:
+		return msg
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for message")
+		return maestro.Message{}
+	}
+}
+
+func testFrame(content string) []byte {
+	return makeBinaryAuthStream(maestro.BinaryAuthContentMessage{
+		Version:     1,
+		AuthSize:    4,
+		Auth:        []byte("auth"),
+		ContentSize: len(content),
+		Content:     []byte(content),
+	})
+}
+
+func TestServer_Start(t *testing.T) {
+	s := maestro.NewServer(nil, maestro.ServerOpts{})
+	require.ErrorIs(t, s.Start(context.Background()), maestro.ErrNoProtocol)
+}
+
+func TestServer_Session(t *testing.T) {
+	ts := startTestServer(t, maestro.ServerOpts{})
+	conn := ts.dial(t)
+
+	// two frames coalesced into a single write
+	_, err := conn.Write(append(testFrame("one"), testFrame("two")...))
+	require.NoError(t, err)
+
+	first := ts.nextMessage(t)
+	require.Equal(t, []byte("one"), first.Content)
+	require.NotEmpty(t, first.ConnID)
+	require.NotNil(t, ts.Peers.GetPeer(first.ConnID), "connection should be registered as a peer")
+	require.Equal(t, []byte("two"), ts.nextMessage(t).Content)
+
+	// a single frame split across writes
+	frame := testFrame("three")
+	for _, b := range frame {
+		_, err = conn.Write([]byte{b})
+		require.NoError(t, err)
+	}
+	third := ts.nextMessage(t)
+	require.Equal(t, []byte("three"), third.Content)
+	require.Equal(t, first.ConnID, third.ConnID)
+
+	conn.Close()
+	require.Eventually(t, func() bool {
+		return ts.Peers.GetPeer(first.ConnID) == nil
+	}, time.Second, time.Millisecond, "peer should be removed on disconnect")
+}
+
+func TestServer_MultipleSessions(t *testing.T) {
+	ts := startTestServer(t, maestro.ServerOpts{})
+
+	a := ts.dial(t)
+	b := ts.dial(t)
+
+	_, err := a.Write(testFrame("a"))
+	require.NoError(t, err)
+	msgA := ts.nextMessage(t)
+
+	_, err = b.Write(testFrame("b"))
+	require.NoError(t, err)
+	msgB := ts.nextMessage(t)
+
+	require.NotEqual(t, msgA.ConnID, msgB.ConnID)
+	require.Equal(t, 2, ts.Peers.Len())
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := maestro.NewServer(l, maestro.ServerOpts{
+		Protocol: &maestro.BinaryAuthContentProtocol{
+			Authenticator: maestro.NewNilAuthenticator(),
+			Parser:        BinaryAuthTestParser{},
+		},
+	})
+	s.Logger = *slog.New(slog.NewTextHandler(io.Discard, nil))
+	require.NoError(t, s.Start(context.Background()))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool {
+		return s.Peers.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, s.Shutdown())
+	require.Zero(t, s.Peers.Len())
+
+	// the server closed our connection
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = net.Dial("tcp", l.Addr().String())
+	require.Error(t, err, "listener should be closed")
+}
+
+func TestServer_ContextCancel(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := maestro.NewServer(l, maestro.ServerOpts{
+		Protocol: &maestro.BinaryAuthContentProtocol{
+			Authenticator: maestro.NewNilAuthenticator(),
+			Parser:        BinaryAuthTestParser{},
+		},
+	})
+	s.Logger = *slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, s.Start(ctx))
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		require.FailNow(t, "server did not stop after context was cancelled")
+	}
+}
+
+func TestServer_MalformedFrameClosesSession(t *testing.T) {
+	ts := startTestServer(t, maestro.ServerOpts{})
+	conn := ts.dial(t)
+
+	_, err := conn.Write(testFrame("one"))
+	require.NoError(t, err)
+	msg := ts.nextMessage(t)
+
+	bad := testFrame("bad")
+	bad[4] = 0x00
+	_, err = conn.Write(bad)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "server should close the connection")
+	require.Eventually(t, func() bool {
+		return ts.Peers.GetPeer(msg.ConnID) == nil
+	}, time.Second, time.Millisecond)
+}
+
+func TestServer_MalformedFrameResync(t *testing.T) {
+	ts := startTestServer(t, maestro.ServerOpts{
+		Frame: maestro.FrameReaderOpts{Resync: true},
+	})
+	conn := ts.dial(t)
+
+	bad := testFrame("bad")
+	bad[4] = 0x00
+	_, err := conn.Write(append(bad, testFrame("good")...))
+	require.NoError(t, err)
+
+	require.Equal(t, []byte("good"), ts.nextMessage(t).Content)
+}
+
+func TestSession_Send(t *testing.T) {
+	reply := testFrameMessage("", "reply")
+	ts := startTestServer(t, maestro.ServerOpts{
+		Handler: maestro.HandlerFunc(func(_ context.Context, s *maestro.Session, _ maestro.Message) error {
+			return s.Send(reply)
+		}),
+	})
+	conn := ts.dial(t)
+
+	_, err := conn.Write(testFrame("ping"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	got, err := maestro.NewFrameReader(conn, maestro.FrameReaderOpts{}).ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, reply, got)
+}
+
+func TestSession_WriteTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	ts := startTestServer(t, maestro.ServerOpts{
+		Handler: maestro.HandlerFunc(func(_ context.Context, s *maestro.Session, _ maestro.Message) error {
+			// more than the socket buffers hold, to a client that never reads
+			_, err := s.Write(make([]byte, 64<<20))
+			errs <- err
+			return nil
+		}),
+		WriteTimeout: 50 * time.Millisecond,
+	})
+	conn := ts.dial(t)
+
+	_, err := conn.Write(testFrame("ping"))
+	require.NoError(t, err)
+
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, maestro.ErrWriteTimeout)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "write did not time out")
+	}
+
+	// the session is closed, as the peer may have been sent part of a frame
+	require.Eventually(t, func() bool {
+		return ts.Peers.Len() == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestPeerMap_Subscriptions(t *testing.T) {
+	pm := maestro.NewPeerMap()
+	pm.AddPeer("1", &maestro.Peer{})
+	pm.AddPeer("2", &maestro.Peer{})
+
+	require.ErrorIs(t, pm.Subscribe("missing", "orders"), maestro.ErrPeerNotFound)
+
+	require.NoError(t, pm.Subscribe("2", "orders"))
+	require.NoError(t, pm.Subscribe("1", "orders"))
+	require.NoError(t, pm.Subscribe("1", "orders"))
+	require.NoError(t, pm.Subscribe("1", "invoices"))
+
+	subs := pm.Subscribers("orders")
+	require.Len(t, subs, 2)
+	require.Equal(t, "1", subs[0].ConnID)
+	require.Equal(t, "2", subs[1].ConnID)
+	require.Equal(t, []string{"invoices", "orders"}, pm.Subscriptions("1"))
+
+	require.NoError(t, pm.Unsubscribe("1", "orders"))
+	require.ErrorIs(t, pm.Unsubscribe("1", "orders"), maestro.ErrNotSubscribed)
+	require.Len(t, pm.Subscribers("orders"), 1)
+	require.Equal(t, []string{"invoices"}, pm.Subscriptions("1"))
+
+	pm.RemoveQueue("orders")
+	require.Empty(t, pm.Subscribers("orders"))
+	require.Empty(t, pm.Subscriptions("2"))
+
+	pm.RemovePeer("1")
+	require.Empty(t, pm.Subscribers("invoices"))
+	require.Empty(t, pm.Subscriptions("1"))
+	require.Nil(t, pm.GetPeer("1"))
+}
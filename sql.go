@@ -0,0 +1,389 @@
+package maestro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SQLWatcher is a Watcher that polls a table through database/sql for rows
+// whose version column has moved past the last one seen. Deletes can't be
+// seen by polling the table, so they are read from an optional change log
+// table kept up to date by a trigger.
+type SQLWatcher struct {
+	db      *sql.DB
+	opts    SQLWatcherOpts
+	columns string
+}
+
+type SQLWatcherOpts struct {
+	// TokenStore saves how far the table has been read so Watch resumes from
+	// there after a restart. The whole table is read again when it is nil.
+	TokenStore TokenStore
+	// OnStateChange is called whenever polling starts, fails or stops. err is
+	// the reason polling failed or stopped, if any.
+	OnStateChange func(state WatcherState, err error)
+	// Placeholder returns the bind parameter for the nth argument of a query,
+	// counting from 1. Defaults to QuestionPlaceholder, use DollarPlaceholder
+	// for Postgres.
+	Placeholder func(n int) string
+	// Table is the table to watch.
+	Table string
+	// IDColumn holds each row's ID. Defaults to "id".
+	IDColumn string
+	// VersionColumn is bumped on every insert and update, such as a sequence
+	// set by a trigger or an updated_at timestamp. Rows are read in version
+	// order, so a transaction that commits after a later version has been read
+	// is missed. Defaults to "version".
+	VersionColumn string
+	// Columns are the columns sent as each update's data. Defaults to every
+	// column.
+	Columns []string
+	// ChangeLogTable is a table with an increasing "seq" column and an "id"
+	// column that a trigger adds deleted rows' IDs to, such as
+	//
+	//	CREATE TABLE orders_changes (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL);
+	//	CREATE TRIGGER orders_deleted AFTER DELETE ON orders BEGIN
+	//		INSERT INTO orders_changes (id) VALUES (old.id);
+	//	END;
+	//
+	// in SQLite. Deletes aren't seen when it is empty.
+	ChangeLogTable string
+	// ResumeKey names this watcher's token in TokenStore. Defaults to Table.
+	ResumeKey string
+	// Reconnect is the backoff between polls after one fails.
+	// DefaultReconnect is used when Initial is 0.
+	Reconnect Backoff
+	// PollInterval is how often the table is checked for changes. Defaults to
+	// DefaultSQLPollInterval.
+	PollInterval time.Duration
+	// BatchSize is the most rows read by one query. Defaults to
+	// DefaultSQLBatchSize.
+	BatchSize int
+}
+
+const (
+	DefaultSQLPollInterval = time.Second
+	DefaultSQLBatchSize    = 500
+)
+
+// QuestionPlaceholder is the bind parameter style of SQLite and MySQL.
+func QuestionPlaceholder(int) string {
+	return "?"
+}
+
+// DollarPlaceholder is the bind parameter style of Postgres.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// sqlCursor is how far a SQLWatcher has read, saved as its resume token.
+type sqlCursor struct {
+	// Version and ID are those of the last row read, nil before the first.
+	Version any `bson:"version"`
+	ID      any `bson:"id"`
+	// Seq is the last change log entry read.
+	Seq int64 `bson:"seq"`
+}
+
+func NewSQLWatcher(db *sql.DB, opts SQLWatcherOpts) (*SQLWatcher, error) {
+	if opts.Table == "" {
+		return nil, errors.New("table name is required")
+	}
+	if opts.Placeholder == nil {
+		opts.Placeholder = QuestionPlaceholder
+	}
+	if opts.IDColumn == "" {
+		opts.IDColumn = "id"
+	}
+	if opts.VersionColumn == "" {
+		opts.VersionColumn = "version"
+	}
+	if opts.ResumeKey == "" {
+		opts.ResumeKey = opts.Table
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultSQLPollInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultSQLBatchSize
+	}
+
+	columns := "*"
+	if len(opts.Columns) > 0 {
+		cols := slices.Clone(opts.Columns)
+		for _, col := range []string{opts.IDColumn, opts.VersionColumn} {
+			if !slices.Contains(cols, col) {
+				cols = append(cols, col)
+			}
+		}
+		columns = strings.Join(cols, ", ")
+	}
+
+	return &SQLWatcher{
+		db:      db,
+		opts:    opts,
+		columns: columns,
+	}, nil
+}
+
+// Watch sends the table's changes on c until ctx is cancelled. Rows are sent
+// as updates, since polling can't tell an insert from an update, and the
+// first poll without a saved token sends the whole table. Each update's
+// Namespace is the table name.
+//
+// Watch returns early if the first poll fails, as that usually means it is
+// misconfigured. Later failures are retried, waiting longer between each
+// attempt as set by Reconnect.
+func (sw *SQLWatcher) Watch(ctx context.Context, c chan QueueUpdateMessage) error {
+	cur, err := sw.loadCursor(ctx)
+	if err != nil {
+		return err
+	}
+
+	backoff := sw.opts.Reconnect
+	if backoff.Initial <= 0 {
+		backoff = DefaultReconnect
+	}
+
+	sw.setState(WatcherStateConnecting, nil)
+	polled, attempt := false, 0
+	for {
+		wait := sw.opts.PollInterval
+		if err := sw.poll(ctx, c, &cur); ctx.Err() != nil {
+			sw.setState(WatcherStateStopped, nil)
+			return ctx.Err()
+		} else if err != nil && !polled {
+			sw.setState(WatcherStateStopped, err)
+			return err
+		} else if err != nil {
+			attempt++
+			sw.setState(WatcherStateReconnecting, err)
+			wait = jitter(backoff.Delay(attempt))
+		} else if !polled || attempt > 0 {
+			polled, attempt = true, 0
+			sw.setState(WatcherStateWatching, nil)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			sw.setState(WatcherStateStopped, nil)
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (sw *SQLWatcher) setState(state WatcherState, err error) {
+	if sw.opts.OnStateChange != nil {
+		sw.opts.OnStateChange(state, err)
+	}
+}
+
+// poll sends every change since cur, moving cur past them.
+func (sw *SQLWatcher) poll(ctx context.Context, c chan QueueUpdateMessage, cur *sqlCursor) error {
+	if sw.opts.ChangeLogTable != "" {
+		for {
+			n, err := sw.pollDeletes(ctx, c, cur)
+			if err != nil {
+				return err
+			} else if n < sw.opts.BatchSize {
+				break
+			}
+		}
+	}
+
+	for {
+		n, err := sw.pollRows(ctx, c, cur)
+		if err != nil {
+			return err
+		} else if n < sw.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+// pollDeletes sends a batch of deletes from the change log and returns how
+// many it read. Rows that exist again have been inserted since they were
+// deleted, so their delete is skipped rather than sent after the insert.
+func (sw *SQLWatcher) pollDeletes(ctx context.Context, c chan QueueUpdateMessage, cur *sqlCursor) (int, error) {
+	//nolint:gosec // identifiers come from the watcher's config, values are bound
+	query := fmt.Sprintf("SELECT l.seq, l.id, t.%[3]s IS NOT NULL FROM %[1]s l LEFT JOIN %[2]s t ON t.%[3]s = l.id"+
+		" WHERE l.seq > %[4]s ORDER BY l.seq LIMIT %[5]d",
+		sw.opts.ChangeLogTable, sw.opts.Table, sw.opts.IDColumn, sw.opts.Placeholder(1), sw.opts.BatchSize)
+
+	rows, err := sw.db.QueryContext(ctx, query, cur.Seq)
+	if err != nil {
+		return 0, fmt.Errorf("poll %s: %w", sw.opts.ChangeLogTable, err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var seq int64
+		var id any
+		var exists bool
+		if err := rows.Scan(&seq, &id, &exists); err != nil {
+			return n, fmt.Errorf("poll %s: %w", sw.opts.ChangeLogTable, err)
+		}
+		n++
+
+		if !exists {
+			if err := send(ctx, c, QueueUpdateMessage{
+				Data:              nil,
+				Before:            nil,
+				UpdateDescription: nil,
+				ID:                sqlString(id),
+				Namespace:         sw.opts.Table,
+				OpType:            OpTypeDelete,
+			}); err != nil {
+				return n, err
+			}
+		}
+		cur.Seq = seq
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("poll %s: %w", sw.opts.ChangeLogTable, err)
+	}
+	if n == 0 {
+		// the cursor hasn't moved, so there is nothing to save
+		return 0, nil
+	}
+
+	return n, sw.saveCursor(ctx, cur)
+}
+
+// pollRows sends a batch of rows changed since cur and returns how many it
+// read. Rows are read in (version, id) order so rows sharing a version aren't
+// skipped between batches.
+func (sw *SQLWatcher) pollRows(ctx context.Context, c chan QueueUpdateMessage, cur *sqlCursor) (int, error) {
+	var where string
+	var args []any
+	if cur.Version != nil {
+		p := sw.opts.Placeholder
+		where = fmt.Sprintf(" WHERE %[1]s > %[3]s OR (%[1]s = %[4]s AND %[2]s > %[5]s)",
+			sw.opts.VersionColumn, sw.opts.IDColumn, p(1), p(2), p(3))
+		args = []any{cur.Version, cur.Version, cur.ID}
+	}
+	//nolint:gosec // identifiers come from the watcher's config, values are bound
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s, %s LIMIT %d",
+		sw.columns, sw.opts.Table, where, sw.opts.VersionColumn, sw.opts.IDColumn, sw.opts.BatchSize)
+
+	rows, err := sw.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("poll %s: %w", sw.opts.Table, err)
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("poll %s: %w", sw.opts.Table, err)
+	}
+
+	n := 0
+	for rows.Next() {
+		values := make([]any, len(names))
+		ptrs := make([]any, len(names))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return n, fmt.Errorf("poll %s: %w", sw.opts.Table, err)
+		}
+		n++
+
+		row := make(map[string]any, len(names))
+		for i, name := range names {
+			row[name] = values[i]
+		}
+
+		if err := send(ctx, c, QueueUpdateMessage{
+			Data:              row,
+			Before:            nil,
+			UpdateDescription: nil,
+			ID:                sqlString(row[sw.opts.IDColumn]),
+			Namespace:         sw.opts.Table,
+			OpType:            OpTypeUpdate,
+		}); err != nil {
+			return n, err
+		}
+		cur.Version, cur.ID = row[sw.opts.VersionColumn], row[sw.opts.IDColumn]
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("poll %s: %w", sw.opts.Table, err)
+	}
+	if n == 0 {
+		// the cursor hasn't moved, so there is nothing to save
+		return 0, nil
+	}
+
+	return n, sw.saveCursor(ctx, cur)
+}
+
+func (sw *SQLWatcher) loadCursor(ctx context.Context) (sqlCursor, error) {
+	cur := sqlCursor{Version: nil, ID: nil, Seq: 0}
+	if sw.opts.TokenStore == nil {
+		return cur, nil
+	}
+
+	token, err := sw.opts.TokenStore.LoadToken(ctx, sw.opts.ResumeKey)
+	if err != nil {
+		return cur, fmt.Errorf("load resume token: %w", err)
+	} else if token == nil {
+		return cur, nil
+	}
+
+	if err := bson.Unmarshal(token, &cur); err != nil {
+		return cur, fmt.Errorf("load resume token: %w", err)
+	}
+	// timestamps come back from BSON as DateTime, which drivers don't accept
+	if v, ok := cur.Version.(primitive.DateTime); ok {
+		cur.Version = v.Time()
+	}
+
+	return cur, nil
+}
+
+// saveCursor saves cur once a batch has been sent. Timestamps are saved to
+// the millisecond, so a few rows may be sent again after a restart.
+func (sw *SQLWatcher) saveCursor(ctx context.Context, cur *sqlCursor) error {
+	if sw.opts.TokenStore == nil {
+		return nil
+	}
+
+	token, err := bson.Marshal(cur)
+	if err != nil {
+		return fmt.Errorf("save resume token: %w", err)
+	}
+	if err := sw.opts.TokenStore.SaveToken(ctx, sw.opts.ResumeKey, token); err != nil {
+		return fmt.Errorf("save resume token: %w", err)
+	}
+
+	return nil
+}
+
+// sqlString formats a column value as an item ID.
+func sqlString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,187 @@
+//go:build cgo
+
+// go-sqlite3 needs cgo, so the SQLWatcher tests only run where it is enabled.
+
+package maestro_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	_, err = db.Exec(`
+		CREATE TABLE orders (id TEXT PRIMARY KEY, status TEXT, version INTEGER NOT NULL);
+		CREATE TABLE orders_changes (seq INTEGER PRIMARY KEY AUTOINCREMENT, id TEXT NOT NULL);
+		CREATE TRIGGER orders_deleted AFTER DELETE ON orders BEGIN
+			INSERT INTO orders_changes (id) VALUES (old.id);
+		END;
+	`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func execSQL(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+
+	_, err := db.Exec(query, args...)
+	require.NoError(t, err)
+}
+
+func watchSQL(t *testing.T, db *sql.DB, opts maestro.SQLWatcherOpts) chan maestro.QueueUpdateMessage {
+	t.Helper()
+
+	opts.Table = "orders"
+	opts.ChangeLogTable = "orders_changes"
+	opts.PollInterval = time.Millisecond
+	w, err := maestro.NewSQLWatcher(db, opts)
+	require.NoError(t, err)
+
+	c, _ := startWatch(t, w)
+	return c
+}
+
+func TestSQLWatcher_Watch(t *testing.T) {
+	db := openTestDB(t)
+	// rows sharing a version aren't skipped when split across batches
+	execSQL(t, db, `INSERT INTO orders (id, status, version) VALUES ('c', 'new', 1), ('b', 'new', 1), ('a', 'new', 1), ('d', 'new', 2)`)
+
+	c := watchSQL(t, db, maestro.SQLWatcherOpts{
+		BatchSize: 2,
+		Columns:   []string{"status"},
+	})
+
+	require.Equal(t, maestro.QueueUpdateMessage{
+		Data:      map[string]any{"id": "a", "status": "new", "version": int64(1)},
+		ID:        "a",
+		Namespace: "orders",
+		OpType:    maestro.OpTypeUpdate,
+	}, receive(t, c))
+	requireIDs(t, c, "b", "c", "d")
+
+	execSQL(t, db, `UPDATE orders SET status = 'ready', version = 3 WHERE id = 'b'`)
+	msg := receive(t, c)
+	require.Equal(t, "b", msg.ID)
+	require.Equal(t, "ready", msg.Data.(map[string]any)["status"])
+
+	execSQL(t, db, `DELETE FROM orders WHERE id = 'a'`)
+	require.Equal(t, maestro.QueueUpdateMessage{
+		ID:        "a",
+		Namespace: "orders",
+		OpType:    maestro.OpTypeDelete,
+	}, receive(t, c))
+
+	// a row deleted and inserted again stays in the queue, whichever poll sees
+	// the insert
+	execSQL(t, db, `DELETE FROM orders WHERE id = 'c'`)
+	execSQL(t, db, `INSERT INTO orders (id, status, version) VALUES ('c', 'again', 4)`)
+	var last maestro.QueueUpdateMessage
+	require.Eventually(t, func() bool {
+		select {
+		case last = <-c:
+		default:
+		}
+		return last.OpType == maestro.OpTypeUpdate && last.ID == "c"
+	}, time.Second, time.Millisecond)
+	requireIDs(t, c)
+}
+
+func TestSQLWatcher_ResumesFromToken(t *testing.T) {
+	db := openTestDB(t)
+	store := newMemoryTokenStore()
+	execSQL(t, db, `INSERT INTO orders (id, status, version) VALUES ('a', 'new', 1)`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err := maestro.NewSQLWatcher(db, maestro.SQLWatcherOpts{
+		TokenStore:     store,
+		Table:          "orders",
+		ChangeLogTable: "orders_changes",
+		PollInterval:   time.Millisecond,
+	})
+	require.NoError(t, err)
+	c := make(chan maestro.QueueUpdateMessage, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Watch(ctx, c)
+	}()
+	requireIDs(t, c, "a")
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+
+	execSQL(t, db, `INSERT INTO orders (id, status, version) VALUES ('b', 'new', 2)`)
+	execSQL(t, db, `DELETE FROM orders WHERE id = 'a'`)
+
+	c = watchSQL(t, db, maestro.SQLWatcherOpts{TokenStore: store})
+	require.Equal(t, maestro.OpTypeDelete, receive(t, c).OpType)
+	requireIDs(t, c, "b")
+}
+
+// countingTokenStore counts the tokens saved to it.
+type countingTokenStore struct {
+	maestro.TokenStore
+	saves atomic.Int32
+}
+
+func (s *countingTokenStore) SaveToken(ctx context.Context, key string, token bson.Raw) error {
+	s.saves.Add(1)
+	return s.TokenStore.SaveToken(ctx, key, token)
+}
+
+func TestSQLWatcher_SavesOnlyWhenCursorMoves(t *testing.T) {
+	db := openTestDB(t)
+	execSQL(t, db, `INSERT INTO orders (id, status, version) VALUES ('a', 'new', 1)`)
+
+	store := &countingTokenStore{TokenStore: newMemoryTokenStore()}
+	c := watchSQL(t, db, maestro.SQLWatcherOpts{TokenStore: store})
+	requireIDs(t, c, "a")
+
+	// polls that find nothing leave the token alone
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(1), store.saves.Load())
+
+	execSQL(t, db, `DELETE FROM orders WHERE id = 'a'`)
+	require.Equal(t, maestro.OpTypeDelete, receive(t, c).OpType)
+	require.Eventually(t, func() bool {
+		return store.saves.Load() == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestSQLWatcher_StopsOnFirstPollError(t *testing.T) {
+	db := openTestDB(t)
+
+	var states []maestro.WatcherState
+	w, err := maestro.NewSQLWatcher(db, maestro.SQLWatcherOpts{
+		Table: "missing",
+		OnStateChange: func(state maestro.WatcherState, _ error) {
+			states = append(states, state)
+		},
+	})
+	require.NoError(t, err)
+
+	err = w.Watch(context.Background(), make(chan maestro.QueueUpdateMessage))
+	require.ErrorContains(t, err, "no such table")
+	require.Equal(t, []maestro.WatcherState{maestro.WatcherStateConnecting, maestro.WatcherStateStopped}, states)
+}
+
+func TestDollarPlaceholder(t *testing.T) {
+	require.Equal(t, "$3", maestro.DollarPlaceholder(3))
+	require.Equal(t, "?", maestro.QuestionPlaceholder(3))
+}
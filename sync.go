@@ -0,0 +1,111 @@
+package maestro
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// SyncContainer wraps a Container so it can be shared between goroutines,
+// such as a Watcher pushing updates while connections pop items.
+type SyncContainer struct {
+	container Container
+	// ready is closed and replaced whenever an item is pushed, waking any
+	// goroutines parked in PopWait.
+	ready chan struct{}
+	mutex sync.Mutex
+}
+
+func NewSyncContainer(c Container) *SyncContainer {
+	return &SyncContainer{
+		container: c,
+		ready:     make(chan struct{}),
+		mutex:     sync.Mutex{},
+	}
+}
+
+// NewSyncSliceContainer returns a SliceContainer that is safe for concurrent use.
+func NewSyncSliceContainer() *SyncContainer {
+	return NewSyncContainer(NewSliceContainer())
+}
+
+func (sc *SyncContainer) Push(item QueueItem) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.container.Push(item)
+	sc.notify()
+}
+
+func (sc *SyncContainer) Requeue(item QueueItem) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.container.Requeue(item)
+	sc.notify()
+}
+
+func (sc *SyncContainer) Pop() (QueueItem, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.container.Pop()
+}
+
+// PopWait pops the next item, blocking until one is available or ctx is done.
+func (sc *SyncContainer) PopWait(ctx context.Context) (QueueItem, error) {
+	for {
+		sc.mutex.Lock()
+		item, err := sc.container.Pop()
+		ready := sc.ready
+		sc.mutex.Unlock()
+
+		if !errors.Is(err, ErrQueueEmpty) {
+			return item, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ready:
+		}
+	}
+}
+
+func (sc *SyncContainer) Len() int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.container.Len()
+}
+
+// Items returns a copy of the wrapped container's items so callers can range
+// over them without holding the lock.
+func (sc *SyncContainer) Items() []QueueItem {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return slices.Clone(sc.container.Items())
+}
+
+func (sc *SyncContainer) Find(id string) (QueueItem, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.container.Find(id)
+}
+
+func (sc *SyncContainer) Remove(id string) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.container.Remove(id)
+}
+
+func (sc *SyncContainer) Replace(id string, item QueueItem) error {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	return sc.container.Replace(id, item)
+}
+
+// notify wakes every goroutine waiting in PopWait. The caller must hold the lock.
+func (sc *SyncContainer) notify() {
+	close(sc.ready)
+	sc.ready = make(chan struct{})
+}
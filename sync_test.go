@@ -0,0 +1,139 @@
+package maestro_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charlieplate/maestro"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSyncContainer(t *testing.T) {
+	tests := []struct {
+		container maestro.Container
+		name      string
+	}{
+		{
+			name:      "Wraps SliceContainer",
+			container: maestro.NewSliceContainer(),
+		},
+		{
+			name:      "Wraps HeapContainer",
+			container: maestro.NewHeapContainer(maestro.QueueConfig{}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := maestro.NewSyncContainer(tt.container)
+			require.Implements(t, (*maestro.Container)(nil), sc)
+
+			for _, item := range makeTestQueueItems(3) {
+				sc.Push(item)
+			}
+			require.Equal(t, 3, sc.Len())
+			require.Equal(t, makeTestQueueItems(3), sc.Items())
+
+			item, err := sc.Find("testId1")
+			require.NoError(t, err)
+			require.Equal(t, testQueueItem(1), item)
+
+			item, err = sc.Pop()
+			require.NoError(t, err)
+			require.Equal(t, testQueueItem(0), item)
+		})
+	}
+}
+
+func TestSyncContainer_PopWait(t *testing.T) {
+	t.Run("Returns Queued Item", func(t *testing.T) {
+		sc := maestro.NewSyncSliceContainer()
+		sc.Push(testQueueItem(0))
+
+		item, err := sc.PopWait(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, testQueueItem(0), item)
+	})
+
+	t.Run("Blocks Until Push", func(t *testing.T) {
+		sc := maestro.NewSyncSliceContainer()
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			sc.Push(testQueueItem(0))
+		}()
+
+		item, err := sc.PopWait(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, testQueueItem(0), item)
+	})
+
+	t.Run("Returns When Context Is Cancelled", func(t *testing.T) {
+		sc := maestro.NewSyncSliceContainer()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		item, err := sc.PopWait(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, item)
+	})
+}
+
+func TestSyncContainer_Concurrent(t *testing.T) {
+	const (
+		producers   = 8
+		consumers   = 8
+		perProducer = 250
+	)
+
+	sc := maestro.NewSyncSliceContainer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan maestro.QueueItem, producers*perProducer)
+	consumerWg := sync.WaitGroup{}
+	for range consumers {
+		consumerWg.Add(1)
+		go func() {
+			defer consumerWg.Done()
+			for {
+				item, err := sc.PopWait(ctx)
+				if err != nil {
+					return
+				}
+				results <- item
+			}
+		}()
+	}
+
+	producerWg := sync.WaitGroup{}
+	for p := range producers {
+		producerWg.Add(1)
+		go func() {
+			defer producerWg.Done()
+			for i := range perProducer {
+				sc.Push(&TestQueueItem{SetID: fmt.Sprintf("%d-%d", p, i)})
+				_ = sc.Len()
+			}
+		}()
+	}
+	producerWg.Wait()
+
+	seen := make(map[string]bool, producers*perProducer)
+	for range producers * perProducer {
+		select {
+		case item := <-results:
+			require.False(t, seen[item.ID()], "item %s popped twice", item.ID())
+			seen[item.ID()] = true
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for consumers")
+		}
+	}
+
+	cancel()
+	consumerWg.Wait()
+	require.Len(t, seen, producers*perProducer)
+	require.Zero(t, sc.Len())
+}